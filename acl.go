@@ -0,0 +1,70 @@
+package serversets
+
+import (
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// Permissions for ACLs, same as Zookeeper.
+const (
+	PermRead   = zk.PermRead
+	PermWrite  = zk.PermWrite
+	PermCreate = zk.PermCreate
+	PermDelete = zk.PermDelete
+	PermAdmin  = zk.PermAdmin
+	PermAll    = zk.PermAll
+)
+
+// An ACL is a Zookeeper access control entry applied to the directories
+// and members created by a server set.
+type ACL struct {
+	Perms  int32
+	Scheme string
+	ID     string
+}
+
+// WorldACL gives the permissions to everyone. WorldACL(PermAll) is the default.
+func WorldACL(perms int32) []ACL {
+	return fromZKACL(zk.WorldACL(perms))
+}
+
+// DigestACL gives the permissions to the user authenticated with DigestAuth.
+func DigestACL(perms int32, user, password string) []ACL {
+	return fromZKACL(zk.DigestACL(perms, user, password))
+}
+
+// Auth is a set of credentials added to the Zookeeper session.
+type Auth struct {
+	Scheme      string
+	Credentials []byte
+}
+
+// DigestAuth returns the credentials for the digest scheme.
+func DigestAuth(user, password string) Auth {
+	return Auth{
+		Scheme:      "digest",
+		Credentials: []byte(user + ":" + password),
+	}
+}
+
+func fromZKACL(acl []zk.ACL) []ACL {
+	result := make([]ACL, 0, len(acl))
+	for _, a := range acl {
+		result = append(result, ACL{Perms: a.Perms, Scheme: a.Scheme, ID: a.ID})
+	}
+
+	return result
+}
+
+// toZKACL converts the acl for the zk library, empty means open to everyone.
+func toZKACL(acl []ACL) []zk.ACL {
+	if len(acl) == 0 {
+		return zk.WorldACL(zk.PermAll)
+	}
+
+	result := make([]zk.ACL, 0, len(acl))
+	for _, a := range acl {
+		result = append(result, zk.ACL{Perms: a.Perms, Scheme: a.Scheme, ID: a.ID})
+	}
+
+	return result
+}
@@ -0,0 +1,71 @@
+package serversets
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDigestACL(t *testing.T) {
+	acl := DigestACL(PermAll, "user", "password")
+	if len(acl) != 1 {
+		t.Fatalf("should have one entry, got %v", acl)
+	}
+
+	// base64(sha1("user:password"))
+	expected := ACL{Perms: PermAll, Scheme: "digest", ID: "user:tpUq/4Pn5A64fVZyQ0gOJ8ZWqkY="}
+	if acl[0] != expected {
+		t.Errorf("incorrect acl, got %v", acl[0])
+	}
+
+	auth := DigestAuth("user", "password")
+	if auth.Scheme != "digest" || string(auth.Credentials) != "user:password" {
+		t.Errorf("incorrect auth, got %v", auth)
+	}
+}
+
+func TestToZKACL(t *testing.T) {
+	if acl := toZKACL(nil); !reflect.DeepEqual(fromZKACL(acl), WorldACL(PermAll)) {
+		t.Errorf("should default to open acl, got %v", acl)
+	}
+
+	acl := DigestACL(PermRead, "user", "password")
+	if result := fromZKACL(toZKACL(acl)); !reflect.DeepEqual(result, acl) {
+		t.Errorf("should convert acl, got %v", result)
+	}
+}
+
+func TestServerSetACL(t *testing.T) {
+	store := NewMemoryStore()
+	acl := DigestACL(PermAll, "user", "password")
+
+	set := NewWithDialer(Test, "gotest", store.Dial)
+	set.ACL = acl
+
+	ep, err := set.RegisterEndpoint("localhost", 1, nil)
+	if err != nil {
+		t.Fatalf("registration failure: %v", err)
+	}
+	defer ep.Close()
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	members := 0
+	for p, node := range store.nodes {
+		if p == "/" {
+			continue
+		}
+
+		if !reflect.DeepEqual(node.acl, acl) {
+			t.Errorf("should apply acl to %s, got %v", p, node.acl)
+		}
+
+		if node.owner != nil {
+			members++
+		}
+	}
+
+	if members != 1 {
+		t.Errorf("should have one member, got %d", members)
+	}
+}
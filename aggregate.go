@@ -0,0 +1,87 @@
+package serversets
+
+import (
+	"sync"
+	"time"
+)
+
+// aggregator is the closing, event and error handling shared by the watches
+// merging several watches, MultiWatch and EnvironmentWatch.
+type aggregator struct {
+	LastEvent  time.Time
+	EventCount int
+	event      chan struct{}
+	errs       chan error
+
+	done chan struct{} // used for closing
+	wg   sync.WaitGroup
+
+	// serializes merging and sending events, since every watch merged has its own goroutine
+	updateLock sync.Mutex
+}
+
+func newAggregator() aggregator {
+	return aggregator{
+		event: make(chan struct{}, 1),
+		errs:  make(chan error, 10),
+		done:  make(chan struct{}),
+	}
+}
+
+// IsClosed returns if this watch has been closed.
+func (a *aggregator) IsClosed() bool {
+	select {
+	case <-a.done:
+		return true
+	default:
+	}
+
+	return false
+}
+
+// shutdown stops the goroutines and waits for them, then calls closed and closes the channels.
+// Only the first call closes anything, the others just wait.
+func (a *aggregator) shutdown(closed func()) {
+	select {
+	case <-a.done:
+		a.wg.Wait()
+		return
+	default:
+	}
+
+	close(a.done)
+	a.wg.Wait()
+
+	closed()
+
+	close(a.event)
+	close(a.errs)
+}
+
+// update merges the watches and sends an event, unless closed.
+func (a *aggregator) update(merge func()) {
+	a.updateLock.Lock()
+	defer a.updateLock.Unlock()
+
+	if a.IsClosed() {
+		return
+	}
+
+	merge()
+
+	a.EventCount++
+	a.LastEvent = time.Now()
+
+	select {
+	case a.event <- struct{}{}:
+	default:
+	}
+}
+
+// reportError sends the error to the errors channel, if there is room.
+func reportError(errs chan error, err error) {
+	select {
+	case errs <- err:
+	default:
+	}
+}
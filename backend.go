@@ -0,0 +1,96 @@
+package serversets
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrNoNode is returned by a Backend when the requested node does not exist.
+	ErrNoNode = errors.New("serversets: node does not exist")
+
+	// ErrSessionClosed is returned by a Backend after its session has been closed or expired.
+	ErrSessionClosed = errors.New("serversets: session closed")
+)
+
+// A Backend is a single session with the store holding the server set members.
+// Zookeeper is the default, but anything that can provide ephemeral sequential
+// members, and child and data watches, can be used. See NewWithDialer.
+type Backend interface {
+	// CreatePath makes sure the directory, and all its parents, exist.
+	// Nodes created get the ACL, an empty ACL means open to everyone.
+	CreatePath(path string, acl []ACL) error
+
+	// CreateMember creates an ephemeral, sequential member with the given data and ACL.
+	// The prefix is the full path of the new node minus the sequence number.
+	// It returns the full path of the new member.
+	CreateMember(prefix string, data []byte, acl []ACL) (string, error)
+
+	// SetMember replaces the data of the member at the given path.
+	// Returns ErrNoNode if the member does not exist.
+	SetMember(path string, data []byte) error
+
+	// DeleteMember removes the member at the given path.
+	DeleteMember(path string) error
+
+	// Get returns the data and stat stored at the given path.
+	// Returns ErrNoNode if the node does not exist.
+	Get(path string) ([]byte, *Stat, error)
+
+	// GetW is the same as Get, but also returns a channel that will be closed
+	// the next time the data changes, the node is deleted or the session ends.
+	GetW(path string) ([]byte, *Stat, <-chan struct{}, error)
+
+	// Children returns the names of the children of the given path.
+	// Returns ErrNoNode if the node does not exist.
+	Children(path string) ([]string, error)
+
+	// ChildrenW returns the names of the children of the given path and a channel
+	// that will be closed the next time the list changes or the session ends.
+	ChildrenW(path string) ([]string, <-chan struct{}, error)
+
+	// SessionEvents returns the channel of session state changes.
+	// An expired session is never recovered, a new one must be dialed.
+	SessionEvents() <-chan SessionEvent
+
+	// Close ends the session. Members created with it will be removed.
+	Close()
+}
+
+// Stat is the metadata the backend keeps for a node.
+type Stat struct {
+	Created  time.Time
+	Modified time.Time
+}
+
+// A Dialer creates a new session with the backend.
+type Dialer func() (Backend, error)
+
+// A SessionEvent is sent by the backend when the state of the session changes.
+type SessionEvent struct {
+	State SessionState
+}
+
+// SessionState is the state of a backend session.
+type SessionState int
+
+// Possible session states.
+const (
+	SessionDisconnected SessionState = iota
+	SessionConnected
+	SessionExpired
+)
+
+func (s SessionState) String() string {
+	switch s {
+	case SessionDisconnected:
+		return "disconnected"
+	case SessionConnected:
+		return "connected"
+	case SessionExpired:
+		return "expired"
+	}
+
+	return fmt.Sprintf("SessionState(%d)", int(s))
+}
@@ -0,0 +1,46 @@
+package serversets
+
+import (
+	"math/rand"
+	"time"
+)
+
+// backoff computes exponentially increasing delays, with jitter,
+// between attempts to reconnect or retry failed operations.
+type backoff struct {
+	min, max time.Duration
+	attempts uint
+}
+
+func newBackoff(min, max time.Duration) *backoff {
+	if min <= 0 {
+		min = time.Millisecond
+	}
+
+	if max < min {
+		max = min
+	}
+
+	return &backoff{min: min, max: max}
+}
+
+// next returns the delay before the next attempt. It is randomly chosen
+// between half and all of the current exponential delay, so many clients
+// failing at the same time don't all retry at the same time.
+func (b *backoff) next() time.Duration {
+	d := b.min << b.attempts
+	if d > b.max || d < b.min {
+		// max reached, or overflow
+		d = b.max
+	} else {
+		b.attempts++
+	}
+
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+// reset should be called after a successful attempt.
+func (b *backoff) reset() {
+	b.attempts = 0
+}
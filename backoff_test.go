@@ -0,0 +1,41 @@
+package serversets
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	b := newBackoff(100*time.Millisecond, time.Second)
+
+	expected := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}
+
+	for i, e := range expected {
+		d := b.next()
+		if d < e/2 || d > e {
+			t.Errorf("attempt %d incorrect delay, got %v", i, d)
+		}
+	}
+
+	b.reset()
+	if d := b.next(); d < 50*time.Millisecond || d > 100*time.Millisecond {
+		t.Errorf("should reset delay, got %v", d)
+	}
+}
+
+func TestBackoffOverflow(t *testing.T) {
+	b := newBackoff(time.Second, 1<<62)
+
+	for i := 0; i < 100; i++ {
+		if d := b.next(); d <= 0 {
+			t.Fatalf("delay should never be negative, got %v", d)
+		}
+	}
+}
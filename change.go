@@ -0,0 +1,119 @@
+package serversets
+
+// A ChangeEvent describes a change to the endpoints of a Watch.
+// If events are not read as fast as they happen they are merged, so Added and Removed
+// are always relative to the Endpoints of the previous event read from the channel.
+type ChangeEvent struct {
+	// Generation is incremented on every change, merged events skip generations.
+	Generation uint64
+
+	Added   []string
+	Removed []string
+
+	// Endpoints and Members are the full new snapshot.
+	Endpoints []string
+	Members   []Member
+
+	// the endpoints this event is relative to, used for merging.
+	previous []string
+}
+
+func newChangeEvent(generation uint64, previous, current []string, members []Member) ChangeEvent {
+	added, removed := diffEndpoints(previous, current)
+	return ChangeEvent{
+		Generation: generation,
+		Added:      added,
+		Removed:    removed,
+		Endpoints:  current,
+		Members:    members,
+		previous:   previous,
+	}
+}
+
+// sendChange sends the event to the channel, which must only have this one sender.
+// If the channel is full, all pending events are merged with this one, so the receiver
+// gets the changes relative to the last event it actually read.
+func sendChange(changes chan ChangeEvent, event ChangeEvent) {
+	sendMerged(
+		func() bool {
+			select {
+			case changes <- event:
+				return true
+			default:
+				return false
+			}
+		},
+		func() ([]string, bool) {
+			select {
+			case pending := <-changes:
+				return pending.previous, true
+			default:
+				return nil, false
+			}
+		},
+		func(previous []string) {
+			event = newChangeEvent(event.Generation, previous, event.Endpoints, event.Members)
+		})
+}
+
+// sendMerged sends an event, relative to a previous list, to a buffered channel which must
+// only have this one sender. If the channel is full, all the pending events are taken out
+// and the event is made relative to the oldest of them. The channel is used through functions
+// that don't block: send sends the event if there is room, receive takes a pending event and
+// returns the list it is relative to, and rebase makes the event relative to the given list.
+func sendMerged(send func() bool, receive func() ([]string, bool), rebase func(previous []string)) {
+	if send() {
+		return
+	}
+
+	var (
+		previous []string
+		pending  bool
+	)
+
+	for {
+		p, ok := receive()
+		if !ok {
+			break
+		}
+
+		if !pending {
+			previous, pending = p, true
+		}
+	}
+
+	if pending {
+		rebase(previous)
+	}
+
+	// the channel is empty, and there are no other senders
+	send()
+}
+
+// diffEndpoints returns the endpoints added and removed. Duplicates are counted,
+// so registering the same host:port twice is an addition.
+func diffEndpoints(previous, current []string) (added, removed []string) {
+	counts := make(map[string]int, len(previous))
+	for _, e := range previous {
+		counts[e]++
+	}
+
+	added = []string{}
+	for _, e := range current {
+		if counts[e] > 0 {
+			counts[e]--
+		} else {
+			added = append(added, e)
+		}
+	}
+
+	removed = []string{}
+	for _, e := range previous {
+		if counts[e] > 0 {
+			counts[e]--
+			removed = append(removed, e)
+		}
+	}
+
+	return added, removed
+}
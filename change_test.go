@@ -0,0 +1,82 @@
+package serversets
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffEndpoints(t *testing.T) {
+	added, removed := diffEndpoints([]string{"a", "b", "c"}, []string{"b", "c", "d"})
+	if !reflect.DeepEqual(added, []string{"d"}) {
+		t.Errorf("incorrect added, got %v", added)
+	}
+
+	if !reflect.DeepEqual(removed, []string{"a"}) {
+		t.Errorf("incorrect removed, got %v", removed)
+	}
+
+	// duplicates are counted
+	added, removed = diffEndpoints([]string{"a"}, []string{"a", "a"})
+	if !reflect.DeepEqual(added, []string{"a"}) {
+		t.Errorf("incorrect added, got %v", added)
+	}
+
+	if len(removed) != 0 {
+		t.Errorf("should not remove anything, got %v", removed)
+	}
+
+	added, removed = diffEndpoints(nil, nil)
+	if added == nil || removed == nil {
+		t.Errorf("should return empty slices")
+	}
+}
+
+func TestSendChangeMerge(t *testing.T) {
+	changes := make(chan ChangeEvent, 1)
+
+	sendChange(changes, newChangeEvent(1, []string{"a"}, []string{"a", "b"}, nil))
+	sendChange(changes, newChangeEvent(2, []string{"a", "b"}, []string{"b", "c"}, nil))
+	sendChange(changes, newChangeEvent(3, []string{"b", "c"}, []string{"b", "c", "d"}, nil))
+
+	event := <-changes
+	if event.Generation != 3 {
+		t.Errorf("should have last generation, got %v", event.Generation)
+	}
+
+	if !reflect.DeepEqual(event.Added, []string{"b", "c", "d"}) {
+		t.Errorf("incorrect added, got %v", event.Added)
+	}
+
+	if !reflect.DeepEqual(event.Removed, []string{"a"}) {
+		t.Errorf("incorrect removed, got %v", event.Removed)
+	}
+
+	if !reflect.DeepEqual(event.Endpoints, []string{"b", "c", "d"}) {
+		t.Errorf("incorrect endpoints, got %v", event.Endpoints)
+	}
+
+	// after reading, the next one is relative to what was read
+	sendChange(changes, newChangeEvent(4, []string{"b", "c", "d"}, []string{"d"}, nil))
+
+	event = <-changes
+	if len(event.Added) != 0 || !reflect.DeepEqual(event.Removed, []string{"b", "c"}) {
+		t.Errorf("incorrect change, got %v %v", event.Added, event.Removed)
+	}
+}
+
+func TestSendChangeBuffered(t *testing.T) {
+	changes := make(chan ChangeEvent, 2)
+
+	sendChange(changes, newChangeEvent(1, []string{}, []string{"a"}, nil))
+	sendChange(changes, newChangeEvent(2, []string{"a"}, []string{"a", "b"}, nil))
+	sendChange(changes, newChangeEvent(3, []string{"a", "b"}, []string{"b"}, nil))
+
+	event := <-changes
+	if event.Generation != 3 {
+		t.Errorf("should merge all pending, got generation %v", event.Generation)
+	}
+
+	if !reflect.DeepEqual(event.Added, []string{"b"}) || len(event.Removed) != 0 {
+		t.Errorf("incorrect change, got %v %v", event.Added, event.Removed)
+	}
+}
@@ -0,0 +1,78 @@
+package serversets
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os/exec"
+	"time"
+)
+
+// TCPCheck returns a ping function that succeeds if a TCP connection
+// to the address can be opened within the timeout.
+func TCPCheck(address string, timeout time.Duration) func() error {
+	return func() error {
+		conn, err := net.DialTimeout("tcp", address, timeout)
+		if err != nil {
+			return err
+		}
+
+		return conn.Close()
+	}
+}
+
+// HTTPCheck returns a ping function that GETs the url and succeeds if the response has
+// the status, 200 if zero, and the body contains match, if not empty, within the timeout.
+func HTTPCheck(url string, status int, match string, timeout time.Duration) func() error {
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	client := &http.Client{Timeout: timeout}
+	return func() error {
+		resp, err := client.Get(url)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode != status {
+			return fmt.Errorf("serversets: %s returned status %d, expected %d", url, resp.StatusCode, status)
+		}
+
+		if match != "" && !bytes.Contains(body, []byte(match)) {
+			return fmt.Errorf("serversets: %s response does not contain %q", url, match)
+		}
+
+		return nil
+	}
+}
+
+// CommandCheck returns a ping function that runs the command and succeeds if it
+// exits with status 0. The command is killed if it doesn't finish within the timeout,
+// zero means no timeout.
+func CommandCheck(timeout time.Duration, name string, args ...string) func() error {
+	return func() error {
+		ctx := context.Background()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		output, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("serversets: %s failed: %v: %s", name, err, bytes.TrimSpace(output))
+		}
+
+		return nil
+	}
+}
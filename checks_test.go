@@ -0,0 +1,76 @@
+package serversets
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTCPCheck(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := TCPCheck(l.Addr().String(), time.Second)
+	if err := check(); err != nil {
+		t.Errorf("should succeed, got %v", err)
+	}
+
+	l.Close()
+	if err := check(); err == nil {
+		t.Errorf("should fail when not listening")
+	}
+}
+
+func TestHTTPCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		fmt.Fprint(w, "status: ok")
+	}))
+	defer server.Close()
+
+	if err := HTTPCheck(server.URL, 0, "", time.Second)(); err != nil {
+		t.Errorf("should succeed, got %v", err)
+	}
+
+	if err := HTTPCheck(server.URL, http.StatusOK, "ok", time.Second)(); err != nil {
+		t.Errorf("should match body, got %v", err)
+	}
+
+	if err := HTTPCheck(server.URL, http.StatusOK, "fail", time.Second)(); err == nil {
+		t.Errorf("should fail if body doesn't match")
+	}
+
+	if err := HTTPCheck(server.URL+"/down", 0, "", time.Second)(); err == nil {
+		t.Errorf("should fail on unexpected status")
+	}
+
+	if err := HTTPCheck(server.URL+"/down", http.StatusServiceUnavailable, "", time.Second)(); err != nil {
+		t.Errorf("should succeed on expected status, got %v", err)
+	}
+}
+
+func TestCommandCheck(t *testing.T) {
+	if err := CommandCheck(time.Second, "sh", "-c", "exit 0")(); err != nil {
+		t.Errorf("should succeed, got %v", err)
+	}
+
+	if err := CommandCheck(time.Second, "sh", "-c", "exit 1")(); err == nil {
+		t.Errorf("should fail on non zero exit")
+	}
+
+	if err := CommandCheck(10*time.Millisecond, "sleep", "1")(); err == nil {
+		t.Errorf("should fail on timeout")
+	}
+
+	if err := CommandCheck(0, "sh", "-c", "exit 0")(); err != nil {
+		t.Errorf("should not time out without a timeout, got %v", err)
+	}
+}
@@ -0,0 +1,276 @@
+package serversets
+
+import (
+	"sync"
+	"time"
+)
+
+// A Client shares one Zookeeper session across many server sets, and all their
+// watches and endpoints. Without it each watch and endpoint has its own session.
+// When the session expires all of them reconnect on the same new session.
+type Client struct {
+	ZKTimeout time.Duration
+
+	// RetryDelay, MaxRetryDelay and ACL are the defaults of the server sets created by
+	// the client, see ServerSet, and are used by environment watches.
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+	ACL           []ACL
+
+	// Auth are the credentials added to the shared session. The Auth of
+	// server sets created by the client is not used.
+	Auth []Auth
+
+	zkServers []string
+	dialer    Dialer
+
+	lock    sync.Mutex
+	session *sharedSession
+}
+
+// NewClient creates a client sharing a session with the given Zookeeper servers.
+// It doesn't connect until a watch or endpoint needs it.
+func NewClient(zookeepers []string) *Client {
+	return &Client{
+		ZKTimeout:     DefaultZKTimeout,
+		RetryDelay:    DefaultRetryDelay,
+		MaxRetryDelay: DefaultMaxRetryDelay,
+		zkServers:     zookeepers,
+	}
+}
+
+// NewClientWithDialer creates a client sharing a session created by the dialer.
+func NewClientWithDialer(dialer Dialer) *Client {
+	c := NewClient(nil)
+	c.dialer = dialer
+
+	return c
+}
+
+// ServerSet creates a new server set using the shared session of this client.
+// The service name must not contain any slashes. Will panic if it does.
+func (c *Client) ServerSet(environment Environment, service string) *ServerSet {
+	ss := New(environment, service, c.zkServers)
+	ss.RetryDelay = c.RetryDelay
+	ss.MaxRetryDelay = c.MaxRetryDelay
+	ss.ACL = c.ACL
+	ss.dialer = c.Dial
+
+	return ss
+}
+
+// Dial returns a handle to the shared session, creating a new session
+// if there is none or it expired. Closing the handle removes the members
+// created with it, the session is closed once all the handles are.
+func (c *Client) Dial() (Backend, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.session != nil {
+		if h := c.session.newHandle(); h != nil {
+			return h, nil
+		}
+	}
+
+	var (
+		backend Backend
+		err     error
+	)
+
+	if c.dialer != nil {
+		backend, err = c.dialer()
+	} else {
+		backend, err = dialZookeeper(c.zkServers, c.ZKTimeout, c.Auth)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.session = newSharedSession(backend)
+	return c.session.newHandle(), nil
+}
+
+// sharedSession is a backend session used by many handles.
+type sharedSession struct {
+	backend Backend
+	done    chan struct{}
+
+	lock    sync.Mutex
+	expired bool
+	closed  bool
+	handles map[*sessionHandle]struct{}
+}
+
+func newSharedSession(backend Backend) *sharedSession {
+	s := &sharedSession{
+		backend: backend,
+		done:    make(chan struct{}),
+		handles: make(map[*sessionHandle]struct{}),
+	}
+
+	go func() {
+		for {
+			select {
+			case event := <-backend.SessionEvents():
+				s.broadcast(event)
+			case <-s.done:
+				return
+			}
+		}
+	}()
+
+	return s
+}
+
+func (s *sharedSession) isExpired() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.expired
+}
+
+// newHandle returns a new handle to the session, nil if the session expired or was closed.
+func (s *sharedSession) newHandle() *sessionHandle {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.expired || s.closed {
+		return nil
+	}
+
+	h := &sessionHandle{
+		session: s,
+		events:  make(chan SessionEvent, 6),
+		members: make(map[string]struct{}),
+	}
+	s.handles[h] = struct{}{}
+
+	return h
+}
+
+// check marks the session as expired if the error says it's gone.
+// Operations may fail before the expired event is received.
+func (s *sharedSession) check(err error) error {
+	if err == ErrSessionClosed {
+		s.lock.Lock()
+		s.expired = true
+		s.lock.Unlock()
+	}
+
+	return err
+}
+
+// broadcast sends the session event to all the handles.
+func (s *sharedSession) broadcast(event SessionEvent) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if event.State == SessionExpired {
+		s.expired = true
+	}
+
+	for h := range s.handles {
+		// same as the zk library, drop events if no one is listening
+		select {
+		case h.events <- event:
+		default:
+		}
+	}
+}
+
+// release removes the handle and closes the session if it was the last one.
+func (s *sharedSession) release(h *sessionHandle) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.handles[h]; !ok {
+		return
+	}
+
+	delete(s.handles, h)
+	if len(s.handles) == 0 {
+		s.closed = true
+		close(s.done)
+		s.backend.Close()
+	}
+}
+
+// sessionHandle implements the Backend interface on a shared session.
+type sessionHandle struct {
+	session *sharedSession
+	events  chan SessionEvent
+
+	lock    sync.Mutex
+	members map[string]struct{} // created by this handle
+}
+
+func (h *sessionHandle) CreatePath(path string, acl []ACL) error {
+	return h.session.check(h.session.backend.CreatePath(path, acl))
+}
+
+func (h *sessionHandle) CreateMember(prefix string, data []byte, acl []ACL) (string, error) {
+	key, err := h.session.backend.CreateMember(prefix, data, acl)
+	if err != nil {
+		return "", h.session.check(err)
+	}
+
+	h.lock.Lock()
+	h.members[key] = struct{}{}
+	h.lock.Unlock()
+
+	return key, nil
+}
+
+func (h *sessionHandle) SetMember(path string, data []byte) error {
+	return h.session.check(h.session.backend.SetMember(path, data))
+}
+
+func (h *sessionHandle) DeleteMember(path string) error {
+	h.lock.Lock()
+	delete(h.members, path)
+	h.lock.Unlock()
+
+	return h.session.check(h.session.backend.DeleteMember(path))
+}
+
+func (h *sessionHandle) Get(path string) ([]byte, *Stat, error) {
+	data, stat, err := h.session.backend.Get(path)
+	return data, stat, h.session.check(err)
+}
+
+func (h *sessionHandle) GetW(path string) ([]byte, *Stat, <-chan struct{}, error) {
+	data, stat, changed, err := h.session.backend.GetW(path)
+	return data, stat, changed, h.session.check(err)
+}
+
+func (h *sessionHandle) Children(path string) ([]string, error) {
+	children, err := h.session.backend.Children(path)
+	return children, h.session.check(err)
+}
+
+func (h *sessionHandle) ChildrenW(path string) ([]string, <-chan struct{}, error) {
+	children, changed, err := h.session.backend.ChildrenW(path)
+	return children, changed, h.session.check(err)
+}
+
+func (h *sessionHandle) SessionEvents() <-chan SessionEvent {
+	return h.events
+}
+
+// Close removes the members created with this handle, since the session
+// may live on, and releases the session.
+func (h *sessionHandle) Close() {
+	h.lock.Lock()
+	members := h.members
+	h.members = make(map[string]struct{})
+	h.lock.Unlock()
+
+	if !h.session.isExpired() {
+		for key := range members {
+			h.session.backend.DeleteMember(key)
+		}
+	}
+
+	h.session.release(h)
+}
@@ -0,0 +1,118 @@
+package serversets
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func numSessions(store *MemoryStore) int {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	return len(store.sessions)
+}
+
+func TestClientSharedSession(t *testing.T) {
+	store := NewMemoryStore()
+	client := NewClientWithDialer(store.Dial)
+
+	set1 := client.ServerSet(Test, "gotest1")
+	set2 := client.ServerSet(Test, "gotest2")
+
+	watch1, err := set1.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch1.Close()
+
+	watch2, err := set2.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch2.Close()
+
+	ep1, err := set1.RegisterEndpoint("localhost", 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep1.Close()
+	<-watch1.Event()
+
+	ep2, err := set2.RegisterEndpoint("localhost", 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-watch2.Event()
+
+	if n := numSessions(store); n != 1 {
+		t.Errorf("should share one session, got %d", n)
+	}
+
+	// the member should go away even though the session lives on
+	ep2.Close()
+	<-watch2.Event()
+
+	if eps := watch2.Endpoints(); len(eps) != 0 {
+		t.Errorf("should remove member on close, got %v", eps)
+	}
+
+	if eps := watch1.Endpoints(); !reflect.DeepEqual(eps, []string{"localhost:1"}) {
+		t.Errorf("should not affect other set, got %v", eps)
+	}
+}
+
+func TestClientSessionExpired(t *testing.T) {
+	store := NewMemoryStore()
+	client := NewClientWithDialer(store.Dial)
+
+	set := client.ServerSet(Test, "gotest")
+	set.RetryDelay = time.Millisecond
+	set.MaxRetryDelay = 10 * time.Millisecond
+
+	watch, err := set.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := set.RegisterEndpoint("localhost", 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-watch.Event()
+
+	store.ExpireSessions()
+
+	// both reconnect, and the endpoint reregisters, on one new session
+	for {
+		<-watch.Event()
+
+		members := watch.Members()
+		if len(members) == 1 && members[0].Sequence == 1 {
+			break
+		}
+	}
+
+	if n := numSessions(store); n != 1 {
+		t.Errorf("should share one new session, got %d", n)
+	}
+
+	// session is closed when everything using it is
+	ep.Close()
+	watch.Close()
+
+	if n := numSessions(store); n != 0 {
+		t.Errorf("should close the session, got %d", n)
+	}
+
+	// and a new one is created when needed
+	watch, err = set.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch.Close()
+
+	if n := numSessions(store); n != 1 {
+		t.Errorf("should create a new session, got %d", n)
+	}
+}
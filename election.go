@@ -0,0 +1,160 @@
+package serversets
+
+import (
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+)
+
+// IsLeader returns true if the member of this endpoint is the leader of the server set,
+// the alive member with the lowest sequence number. Only tracked if the endpoint was
+// registered with the LeaderElection option. An endpoint that is unhealthy, draining,
+// or disconnected from Zookeeper is never the leader.
+func (ep *Endpoint) IsLeader() bool {
+	ep.registrationLock.RLock()
+	defer ep.registrationLock.RUnlock()
+
+	return ep.leader
+}
+
+// Leadership returns a channel that gets the new leadership whenever it changes.
+// If not read, only the latest change is kept. It is closed when the endpoint is closed.
+func (ep *Endpoint) Leadership() <-chan bool {
+	return ep.leadership
+}
+
+func (ep *Endpoint) setLeader(leader bool) {
+	ep.registrationLock.Lock()
+	changed := ep.leader != leader
+	ep.leader = leader
+	ep.registrationLock.Unlock()
+
+	if !changed {
+		return
+	}
+
+	// only the latest leadership matters, replace a pending one
+	select {
+	case <-ep.leadership:
+	default:
+	}
+
+	ep.leadership <- leader
+}
+
+// elect updates the leadership of the endpoint. To avoid all the members waking up
+// on every change, only the members with a lower sequence number are watched, down to
+// the nearest alive one. Zookeeper is only checked again once one of them changes
+// or the member is created again, e.g. in a new session, otherwise the last result is used.
+func (ep *Endpoint) elect(connection Backend) error {
+	if !ep.options.LeaderElection {
+		return nil
+	}
+
+	if ep.key == "" || ep.draining || !ep.alive || ep.status != StatusAlive {
+		ep.setLeader(false)
+		return nil
+	}
+
+	if ep.electedKey == ep.key {
+		// nothing changed before this member, the watches are still pending
+		ep.setLeader(ep.leading)
+		return nil
+	}
+
+	children, err := connection.Children(ep.directoryPath())
+	if err != nil {
+		return err
+	}
+
+	sequence := memberSequence(path.Base(ep.key))
+
+	var predecessors []string
+	for _, c := range children {
+		if !strings.HasPrefix(c, MemberPrefix) {
+			continue
+		}
+
+		if s := memberSequence(c); s >= 0 && s < sequence {
+			predecessors = append(predecessors, c)
+		}
+	}
+
+	// nearest first, sequence numbers are zero padded
+	sort.Sort(sort.Reverse(sort.StringSlice(predecessors)))
+
+	leading := true
+	for _, p := range predecessors {
+		data, err := ep.watchPredecessor(connection, p)
+		if err == ErrNoNode {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		e := &entity{}
+		if json.Unmarshal(data, e) == nil && e.Status == StatusAlive {
+			// this one, or one in between becoming alive, must change first
+			leading = false
+			break
+		}
+	}
+
+	ep.electedKey = ep.key
+	ep.leading = leading
+
+	ep.setLeader(leading)
+	return nil
+}
+
+// watchPredecessor reads the data of the predecessor, and watches it for changes if not already watching.
+func (ep *Endpoint) watchPredecessor(connection Backend, name string) ([]byte, error) {
+	p := ep.directoryPath() + "/" + name
+	if _, ok := ep.electionWatched[name]; ok {
+		data, _, err := connection.Get(p)
+		return data, err
+	}
+
+	data, _, changed, err := connection.GetW(p)
+	if err != nil {
+		return nil, err
+	}
+	ep.electionWatched[name] = struct{}{}
+
+	go func() {
+		select {
+		case <-changed:
+		case <-ep.done:
+			return
+		}
+
+		select {
+		case ep.electionEvents <- name:
+		case <-ep.done:
+		}
+	}()
+
+	return data, nil
+}
+
+// electionChanged forgets the predecessor watches that fired, the one for the name and any
+// other pending, so they are watched again by the next election.
+func (ep *Endpoint) electionChanged(name string) {
+	names := []string{name}
+	for pending := true; pending; {
+		select {
+		case name := <-ep.electionEvents:
+			names = append(names, name)
+		default:
+			pending = false
+		}
+	}
+
+	for _, name := range names {
+		delete(ep.electionWatched, name)
+	}
+	ep.electedKey = ""
+}
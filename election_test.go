@@ -0,0 +1,223 @@
+package serversets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitLeader waits for the leadership of the endpoint to become the given one.
+func waitLeader(t *testing.T, ep *Endpoint, leader bool) {
+	timeout := time.After(time.Second)
+	for ep.IsLeader() != leader {
+		select {
+		case <-ep.Leadership():
+		case <-timeout:
+			t.Fatalf("leadership should be %v", leader)
+		}
+	}
+}
+
+func TestEndpointLeaderElection(t *testing.T) {
+	set := newTestSet()
+	options := EndpointOptions{LeaderElection: true}
+
+	ep1, err := set.RegisterEndpointWithOptions("localhost", 1, nil, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep1.Close()
+
+	ep2, err := set.RegisterEndpointWithOptions("localhost", 2, nil, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep2.Close()
+
+	ep3, err := set.RegisterEndpointWithOptions("localhost", 3, nil, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep3.Close()
+
+	if !ep1.IsLeader() {
+		t.Errorf("lowest sequence should be the leader")
+	}
+
+	if ep2.IsLeader() || ep3.IsLeader() {
+		t.Errorf("should only have one leader")
+	}
+
+	if l := <-ep1.Leadership(); !l {
+		t.Errorf("should send leadership change, got %v", l)
+	}
+
+	ep1.Close()
+	if l, ok := <-ep1.Leadership(); !ok || l {
+		t.Errorf("should lose leadership when closed, got %v", l)
+	}
+
+	waitLeader(t, ep2, true)
+	if ep3.IsLeader() {
+		t.Errorf("should only have one leader")
+	}
+}
+
+func TestEndpointLeaderUnhealthy(t *testing.T) {
+	set := newTestSet()
+
+	var healthy int32 = 1
+	ep1, err := set.RegisterEndpointWithOptions("localhost", 1, nil, EndpointOptions{
+		LeaderElection: true,
+		HealthCheck: &HealthCheck{
+			Check: func(ctx context.Context) error {
+				if atomic.LoadInt32(&healthy) == 0 {
+					return errors.New("unhealthy")
+				}
+				return nil
+			},
+			Interval: time.Millisecond,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep1.Close()
+
+	ep2, err := set.RegisterEndpointWithOptions("localhost", 2, nil, EndpointOptions{LeaderElection: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep2.Close()
+
+	atomic.StoreInt32(&healthy, 0)
+	waitLeader(t, ep1, false)
+	waitLeader(t, ep2, true)
+
+	// the member is kept while unhealthy, so it leads again once healthy
+	atomic.StoreInt32(&healthy, 1)
+	waitLeader(t, ep1, true)
+	waitLeader(t, ep2, false)
+
+	// draining leaves the election right away
+	go ep1.Drain(context.Background())
+	waitLeader(t, ep2, true)
+}
+
+func TestEndpointLeaderElectionDisabled(t *testing.T) {
+	set := newTestSet()
+
+	ep, err := set.RegisterEndpoint("localhost", 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Close()
+
+	if ep.IsLeader() {
+		t.Errorf("should not be the leader without leader election")
+	}
+}
+
+func TestEndpointLeaderElectionReusesWatches(t *testing.T) {
+	store := NewMemoryStore()
+	set := NewWithDialer(Test, "gotest", store.Dial)
+	options := EndpointOptions{LeaderElection: true}
+
+	ep1, err := set.RegisterEndpointWithOptions("localhost", 1, nil, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep1.Close()
+
+	ep2, err := set.RegisterEndpointWithOptions("localhost", 2, nil, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep2.Close()
+
+	// updates that don't change the members before it don't watch them again
+	for i := 0; i < 10; i++ {
+		if err := ep2.SetMetadata(map[string]string{"i": fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store.lock.Lock()
+	watches := len(store.nodes[set.directoryPath()+"/"+ep1.MemberName()].watches)
+	store.lock.Unlock()
+
+	if watches != 1 {
+		t.Errorf("should only watch the predecessor once, got %d watches", watches)
+	}
+
+	if ep2.IsLeader() {
+		t.Errorf("should not be the leader")
+	}
+
+	ep1.Close()
+	waitLeader(t, ep2, true)
+}
+
+func TestEndpointLeaderElectionRewatchesChanged(t *testing.T) {
+	store := NewMemoryStore()
+	set := NewWithDialer(Test, "gotest", store.Dial)
+
+	ep1, err := set.RegisterEndpoint("localhost", 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep1.Close()
+
+	ep2, err := set.RegisterEndpoint("localhost", 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep2.Close()
+
+	if err := ep2.SetStatus(StatusWarning); err != nil {
+		t.Fatal(err)
+	}
+
+	ep3, err := set.RegisterEndpointWithOptions("localhost", 3, nil, EndpointOptions{LeaderElection: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep3.Close()
+
+	watches := func(ep *Endpoint) int {
+		store.lock.Lock()
+		defer store.lock.Unlock()
+
+		return len(store.nodes[set.directoryPath()+"/"+ep.MemberName()].watches)
+	}
+
+	// every change of the predecessor in between elects again,
+	// only that one is watched again, not the alive one before it
+	for i := 0; i < 10; i++ {
+		if err := ep2.SetMetadata(map[string]string{"i": fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+
+		deadline := time.Now().Add(time.Second)
+		for watches(ep2) != 1 {
+			if time.Now().After(deadline) {
+				t.Fatalf("should watch the changed predecessor again")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	if w := watches(ep1); w != 1 {
+		t.Errorf("should only watch the alive predecessor once, got %d watches", w)
+	}
+
+	if ep3.IsLeader() {
+		t.Errorf("should not be the leader")
+	}
+
+	ep1.Close()
+	waitLeader(t, ep3, true)
+}
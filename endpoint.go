@@ -1,115 +1,501 @@
 package serversets
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"path"
 	"sync"
 	"time"
+)
 
-	"github.com/samuel/go-zookeeper/zk"
+var (
+	// ErrEndpointClosed is returned when updating an endpoint that has been closed.
+	ErrEndpointClosed = errors.New("serversets: endpoint closed")
 )
 
 // An Endpoint is a service (host and port) registered on Zookeeper
 // to be discovered by clients/watchers.
 type Endpoint struct {
 	*ServerSet
-	PingRate   time.Duration // default/initial is 1 second
+	PingRate   time.Duration // default/initial is 1 second, use SetPingRate once registered
 	CloseEvent chan struct{}
 
-	done chan struct{}
-	wg   sync.WaitGroup
+	done  chan struct{}
+	wg    sync.WaitGroup
+	errs  chan error
+	state *connState
+
+	host    string
+	port    int
+	options EndpointOptions
+
+	// health gets the result of the ping when it changes
+	health chan bool
+
+	// updates gets the changes to apply to the member, e.g. draining or a new status
+	updates chan endpointUpdate
+
+	// nil if the endpoint has no health check
+	checker *healthChecker
+
+	// leadership gets the latest leadership change, see Leadership
+	leadership chan bool
+
+	// electionEvents gets the name of the predecessors whose watch fired
+	electionEvents chan string
+
+	// lock for read/writing the registration state, leadership, member name and ping rate
+	registrationLock sync.RWMutex
+	registration     EndpointState
+	pingRate         time.Duration
+	leader           bool
+	name             string
+	renamed          chan struct{} // closed when the name changes
+
+	// only read/written by the session goroutine once registered
+	key      string
+	data     []byte // as last written to the member
+	alive    bool
+	draining bool
+	status   string
+
+	// the leadership of the member last elected, still valid until one of the
+	// watched predecessors changes or the member does, see elect
+	electedKey      string
+	leading         bool
+	electionWatched map[string]struct{}
+}
+
+// endpointUpdate is a change to the member applied by the session goroutine.
+type endpointUpdate struct {
+	apply  func()
+	result chan error
+}
+
+// EndpointOptions are the optional parts of the member data advertised by an endpoint.
+type EndpointOptions struct {
+	// AdditionalEndpoints are named endpoints, e.g. "admin" or "health",
+	// advertised along side the service endpoint.
+	AdditionalEndpoints map[string]MemberEndpoint
+
+	// Shard is the Finagle shard id of this endpoint, nil for none.
+	Shard *int
+
+	// Metadata is arbitrary data stored with the member.
+	Metadata map[string]string
+
+	// Weight is the relative share of the traffic this endpoint should get from
+	// weighted consumers, e.g. httpset and thriftset. 0 means the default of 1.
+	Weight int
+
+	// Zone and Region are where the endpoint runs, e.g. "us-east-1a" and "us-east-1",
+	// so consumers can prefer endpoints in their own zone. See Locality.
+	Zone   string
+	Region string
 
-	host string
-	port int
+	// LeaderElection makes the endpoint track if its member is the leader of the set,
+	// the alive member with the lowest sequence number. See Endpoint.IsLeader.
+	LeaderElection bool
 
-	key   string
-	ping  func() error
-	alive bool
+	// HealthCheck configures the timeout, thresholds and interval of the ping,
+	// or a context aware check to use instead. Nil pings at the ping rate and
+	// marks the member WARNING on the first failure.
+	HealthCheck *HealthCheck
 }
 
 // RegisterEndpoint registers a host and port as alive. It creates the appropriate
 // Zookeeper nodes and watchers will be notified this server/endpoint is available.
 func (ss *ServerSet) RegisterEndpoint(host string, port int, ping func() error) (*Endpoint, error) {
+	return ss.RegisterEndpointWithOptions(host, port, ping, EndpointOptions{})
+}
+
+// RegisterEndpointWithOptions registers a host and port as alive, same as RegisterEndpoint,
+// but also advertises the additional endpoints, shard and metadata in the options.
+func (ss *ServerSet) RegisterEndpointWithOptions(host string, port int, ping func() error, options EndpointOptions) (*Endpoint, error) {
 	endpoint := &Endpoint{
 		ServerSet:  ss,
 		PingRate:   time.Second,
 		CloseEvent: make(chan struct{}, 1),
 		done:       make(chan struct{}),
+		errs:       make(chan error, 10),
+		state:      newConnState(),
+		health:     make(chan bool),
+		updates:    make(chan endpointUpdate),
+		leadership: make(chan bool, 1),
+		renamed:    make(chan struct{}),
 		host:       host,
 		port:       port,
-		ping:       ping,
+		options:    options.copy(),
 		alive:      true,
+		status:     StatusAlive,
+
+		electionEvents:  make(chan string),
+		electionWatched: make(map[string]struct{}),
 	}
 
-	if ping != nil {
-		endpoint.alive = endpoint.ping() == nil
+	hc := HealthCheck{}
+	if options.HealthCheck != nil {
+		hc = *options.HealthCheck
 	}
 
-	connection, sessionEvents, err := ss.connectToZookeeper()
+	if ping != nil || hc.Check != nil {
+		endpoint.checker = newHealthChecker(hc, ping)
+		endpoint.alive = endpoint.checker.first(context.Background())
+	}
+
+	connection, err := ss.connect()
 	if err != nil {
 		return nil, err
 	}
 
 	err = endpoint.update(connection)
 	if err != nil {
+		connection.Close()
 		return nil, err
 	}
 
-	// spawn goroutine to deal with connection/session issues.
+	err = endpoint.elect(connection)
+	if err != nil {
+		connection.Close()
+		return nil, err
+	}
+
+	endpoint.setRegistration(endpoint.registrationState())
+
 	endpoint.wg.Add(1)
-	go func() {
-		defer endpoint.wg.Done()
-		for {
-			select {
-			case event := <-sessionEvents:
-				if event.Type == zk.EventSession && event.State == zk.StateExpired {
-					connection.Close()
-					connection = nil
+	go endpoint.run(connection)
+
+	if endpoint.checker != nil {
+		endpoint.pingRate = endpoint.PingRate
+		endpoint.wg.Add(1)
+		go endpoint.check(endpoint.alive)
+	}
+
+	return endpoint, nil
+}
+
+// run is the state machine of the endpoint. It owns the connection, key and alive state,
+// and is the only one to write to Zookeeper once registered. It deals with session
+// issues, retries, health and leadership changes until closed.
+func (ep *Endpoint) run(connection Backend) {
+	defer ep.wg.Done()
+
+	backoff := ep.newBackoff()
+	var (
+		retry   <-chan time.Time
+		updated chan error
+	)
+
+	for {
+		var sessionEvents <-chan SessionEvent
+		if connection != nil {
+			sessionEvents = connection.SessionEvents()
+		}
+
+		select {
+		case event := <-sessionEvents:
+			ep.state.sessionEvent(event)
+
+			switch event.State {
+			case SessionDisconnected:
+				// can't tell if still the leader until reconnected
+				ep.setLeader(false)
+				continue
+			case SessionConnected:
+				if !ep.options.LeaderElection {
+					continue
 				}
-			case <-endpoint.done:
+				// restore the leadership, the watches outlive the disconnection
+			case SessionExpired:
+				// the member went away with the session
+				ep.metrics().Count(MetricSessionExpirations, 1)
+				ep.setRegistration(EndpointReconnecting)
+				ep.setLeader(false)
 				connection.Close()
-				return
+				connection = nil
+				ep.setKey("")
+				ep.electionWatched = make(map[string]struct{})
 			}
+		case name := <-ep.electionEvents:
+			ep.electionChanged(name)
+		case alive := <-ep.health:
+			ep.alive = alive
+		case u := <-ep.updates:
+			u.apply()
+			ep.setRegistration(ep.registrationState())
 
-			if connection == nil {
-				connection, sessionEvents, err = ss.connectToZookeeper()
-				if err != nil {
-					panic(fmt.Errorf("unable to reconnect to zookeeper after session expired: %v", err))
+			if retry != nil {
+				// not registered, applied once reconnected
+				u.result <- nil
+			} else {
+				updated = u.result
+			}
+		case <-retry:
+			retry = nil
+		case <-ep.done:
+			if connection != nil {
+				if ep.key != "" && connection.DeleteMember(ep.key) == nil {
+					ep.metrics().Count(MetricUnregistrations, 1)
 				}
+				connection.Close()
+			}
+			return
+		}
 
-				err = endpoint.update(connection)
-				if err != nil {
-					panic(fmt.Errorf("unable to reregister endpoint after session expired: %v", err))
-				}
+		if retry != nil {
+			// already failing, wait for the retry to update
+			continue
+		}
+
+		reconnect := connection == nil
+
+		var err error
+		connection, err = ep.refresh(connection)
+		if updated != nil {
+			updated <- err
+			updated = nil
+		}
+
+		if err != nil {
+			if connection == nil {
+				ep.state.set(StateExpired)
+				ep.setRegistration(EndpointReconnecting)
 			}
+			reportError(ep.errs, err)
+			retry = time.After(backoff.next())
+			continue
+		}
+
+		ep.setRegistration(ep.registrationState())
+		if reconnect {
+			ep.state.set(StateReregistered)
+		}
+
+		err = ep.elect(connection)
+		if err != nil {
+			ep.setLeader(false)
+			reportError(ep.errs, fmt.Errorf("unable to check leadership: %v", err))
+			retry = time.After(backoff.next())
+			continue
 		}
+
+		backoff.reset()
+	}
+}
+
+// check runs the health check and sends the changes to the run goroutine.
+func (ep *Endpoint) check(alive bool) {
+	defer ep.wg.Done()
+
+	// cancel the check in progress when closing
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		<-ep.done
+		cancel()
 	}()
 
-	if ping != nil {
-		endpoint.wg.Add(1)
-		go func() {
-			defer endpoint.wg.Done()
-			for {
-				select {
-				case <-time.After(endpoint.PingRate):
-				case <-endpoint.done:
-					return
-				}
+	for {
+		select {
+		case <-time.After(ep.checker.next(ep.currentPingRate())):
+		case <-ep.done:
+			return
+		}
 
-				alive := endpoint.ping() == nil
-				if alive != endpoint.alive {
-					endpoint.alive = alive
-					err := endpoint.update(connection)
+		if a := ep.checker.check(ctx); a != alive {
+			alive = a
+			if alive {
+				ep.metrics().Count(MetricPingHealthy, 1)
+			} else {
+				ep.metrics().Count(MetricPingUnhealthy, 1)
+			}
 
-					if err != nil {
-						panic(fmt.Errorf("unable to reregister after ping change: %v", err))
-					}
-				}
+			select {
+			case ep.health <- alive:
+			case <-ep.done:
+				return
 			}
-		}()
+		}
 	}
+}
 
-	return endpoint, nil
+// SetPingRate changes how often the ping is checked, from the next check on.
+// Not used if the HealthCheck has an Interval.
+func (ep *Endpoint) SetPingRate(rate time.Duration) {
+	ep.registrationLock.Lock()
+	defer ep.registrationLock.Unlock()
+
+	ep.pingRate = rate
+}
+
+func (ep *Endpoint) currentPingRate() time.Duration {
+	ep.registrationLock.RLock()
+	defer ep.registrationLock.RUnlock()
+
+	return ep.pingRate
+}
+
+// Registration returns the current state of the registration of the endpoint.
+func (ep *Endpoint) Registration() EndpointState {
+	ep.registrationLock.RLock()
+	defer ep.registrationLock.RUnlock()
+
+	return ep.registration
+}
+
+func (ep *Endpoint) setRegistration(state EndpointState) {
+	ep.registrationLock.Lock()
+	defer ep.registrationLock.Unlock()
+
+	ep.registration = state
+}
+
+// MemberName returns the name of the znode of the member of this endpoint, e.g. member_0000000318,
+// empty if not registered at the moment. It changes if the member is created again, e.g. after
+// the session expires.
+func (ep *Endpoint) MemberName() string {
+	name, _ := ep.memberName()
+	return name
+}
+
+// memberName returns the name of the member and a channel closed when it changes.
+func (ep *Endpoint) memberName() (string, <-chan struct{}) {
+	ep.registrationLock.RLock()
+	defer ep.registrationLock.RUnlock()
+
+	return ep.name, ep.renamed
+}
+
+// setKey sets the path of the member, and its name, empty if not registered.
+func (ep *Endpoint) setKey(key string) {
+	ep.key = key
+
+	name := ""
+	if key != "" {
+		name = path.Base(key)
+	}
+
+	ep.registrationLock.Lock()
+	defer ep.registrationLock.Unlock()
+
+	if name != ep.name {
+		ep.name = name
+		close(ep.renamed)
+		ep.renamed = make(chan struct{})
+	}
+}
+
+// registrationState returns the state matching the alive state once up to date.
+func (ep *Endpoint) registrationState() EndpointState {
+	if ep.draining {
+		return EndpointDraining
+	}
+
+	if ep.alive {
+		return EndpointRegistered
+	}
+
+	return EndpointUnhealthy
+}
+
+// Drain gracefully removes the endpoint, the way Finagle servers leave a server set.
+// It sets the member status to STOPPING, which watches drop from their endpoints, waits
+// the DrainGracePeriod for clients to observe it, then removes the member and closes.
+// If the context is done first it stops waiting and closes right away.
+// Returns the error setting the status, or of the context.
+func (ep *Endpoint) Drain(ctx context.Context) error {
+	result, err := ep.send(func() { ep.draining = true })
+	if err != nil {
+		// already closed
+		return nil
+	}
+
+	select {
+	case err = <-result:
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-ep.done:
+		return nil
+	}
+
+	if err == nil {
+		select {
+		case <-time.After(ep.DrainGracePeriod):
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	ep.Close()
+	return err
+}
+
+// SetStatus changes the status of the member in place, e.g. to StatusWarning, keeping
+// its name and sequence number. While the ping fails the status is WARNING, and STOPPING
+// once draining, regardless. Returns the error updating the member, nil if it is
+// not registered at the moment, it is updated once reregistered.
+func (ep *Endpoint) SetStatus(status string) error {
+	return ep.wait(ep.send(func() { ep.status = status }))
+}
+
+// SetMetadata replaces the metadata of the member in place, keeping its name
+// and sequence number. Returns the same errors as SetStatus.
+func (ep *Endpoint) SetMetadata(metadata map[string]string) error {
+	options := EndpointOptions{Metadata: metadata}.copy()
+	return ep.wait(ep.send(func() { ep.options.Metadata = options.Metadata }))
+}
+
+// send passes the change to the session goroutine, it returns the channel
+// of the result of updating the member.
+func (ep *Endpoint) send(apply func()) (chan error, error) {
+	u := endpointUpdate{
+		apply:  apply,
+		result: make(chan error, 1),
+	}
+
+	select {
+	case ep.updates <- u:
+		return u.result, nil
+	case <-ep.done:
+		return nil, ErrEndpointClosed
+	}
+}
+
+// wait waits for the result of an update sent to the session goroutine.
+func (ep *Endpoint) wait(result chan error, err error) error {
+	if err != nil {
+		return err
+	}
+
+	select {
+	case err = <-result:
+		return err
+	case <-ep.done:
+		return ErrEndpointClosed
+	}
+}
+
+// Errors returns a channel that gets the errors encountered while reconnecting
+// to Zookeeper or updating the registration. The endpoint keeps retrying, with backoff,
+// after an error. Errors are dropped if the channel is not read.
+func (ep *Endpoint) Errors() <-chan error {
+	return ep.errs
+}
+
+// State returns the current state of the connection to Zookeeper.
+// StateConnected and StateReregistered mean the registration is up to date.
+func (ep *Endpoint) State() ConnState {
+	return ep.state.get()
+}
+
+// StateChanges returns a channel that gets the new state whenever the state
+// of the connection to Zookeeper changes. Changes are dropped if the channel is not read.
+func (ep *Endpoint) StateChanges() <-chan ConnState {
+	return ep.state.changes
 }
 
 // Close blocks until the client connection to Zookeeper is closed.
@@ -123,40 +509,147 @@ func (ep *Endpoint) Close() {
 
 	close(ep.done)
 	ep.wg.Wait()
+	ep.setRegistration(EndpointClosed)
+	ep.setLeader(false)
 	ep.CloseEvent <- struct{}{}
 
+	// the goroutines must be terminated before closing
+	// this channel, since they might still be sending errors.
+	close(ep.errs)
+	close(ep.state.changes)
+	close(ep.leadership)
+
 	return
 }
 
-func (ep *Endpoint) update(connection *zk.Conn) error {
-	// don't create/remove the node if we're dead
-	if !ep.alive {
-		if ep.key != "" {
-			err := connection.Delete(ep.key, 0)
-			ep.key = ""
+// refresh reconnects, if necessary, and makes sure the member matches the state of the endpoint.
+// If the session is gone the connection is closed, and nil returned, so the next attempt starts over.
+// Other errors keep the connection and member, so retrying keeps the same member name.
+func (ep *Endpoint) refresh(connection Backend) (Backend, error) {
+	var err error
+	if connection == nil {
+		ep.metrics().Count(MetricReconnects, 1)
+		connection, err = ep.ServerSet.connect()
+		if err != nil {
+			ep.metrics().Count(MetricReconnectErrors, 1)
+			return nil, fmt.Errorf("unable to reconnect to zookeeper: %v", err)
+		}
+	}
+
+	err = ep.update(connection)
+	if err == ErrSessionClosed {
+		connection.Close()
+		ep.setKey("")
+		return nil, fmt.Errorf("unable to update endpoint registration: %v", err)
+	}
+
+	if err != nil {
+		// keep the session, and the member, the update is retried
+		return connection, fmt.Errorf("unable to update endpoint registration: %v", err)
+	}
+
+	return connection, nil
+}
+
+// update makes the member match the state of the endpoint. The member is only created once,
+// status and metadata changes are applied to its data in place so it keeps the same name.
+func (ep *Endpoint) update(connection Backend) error {
+	entityData := ep.entityData()
+
+	if ep.key != "" {
+		if bytes.Equal(entityData, ep.data) {
+			// already up to date
+			return nil
+		}
+
+		err := connection.SetMember(ep.key, entityData)
+		if err != ErrNoNode {
+			if err == nil {
+				ep.data = entityData
+			}
 			return err
 		}
 
+		// removed from under us, create it again
+		ep.setKey("")
+	}
+
+	if ep.draining || !ep.alive {
+		// never registered again once draining, and not until healthy
 		return nil
 	}
 
-	entityData, _ := json.Marshal(newEntity(ep.host, ep.port))
+	start := time.Now()
 
-	var err error
-	ep.key, err = ep.ServerSet.registerEndpoint(connection, entityData)
+	key, err := ep.ServerSet.registerEndpoint(connection, entityData)
+	if err != nil {
+		return err
+	}
+	ep.setKey(key)
+	ep.data = entityData
 
-	return err
+	ep.metrics().Count(MetricRegistrations, 1)
+	ep.metrics().Timing(MetricRegisterTime, time.Since(start))
+
+	return nil
+}
+
+// entityData returns the member data for the current state of the endpoint.
+func (ep *Endpoint) entityData() []byte {
+	e := newEntity(ep.host, ep.port, ep.options)
+
+	switch {
+	case ep.draining:
+		e.Status = StatusStopping
+	case !ep.alive:
+		e.Status = StatusWarning
+	default:
+		e.Status = ep.status
+	}
+
+	entityData, _ := json.Marshal(e)
+	return entityData
+}
+
+// copy makes a deep copy of the options so they can't be changed after registering.
+func (o EndpointOptions) copy() EndpointOptions {
+	c := EndpointOptions{
+		AdditionalEndpoints: make(map[string]MemberEndpoint, len(o.AdditionalEndpoints)),
+		Weight:              o.Weight,
+		Zone:                o.Zone,
+		Region:              o.Region,
+		LeaderElection:      o.LeaderElection,
+	}
+
+	for k, v := range o.AdditionalEndpoints {
+		c.AdditionalEndpoints[k] = v
+	}
+
+	if o.Shard != nil {
+		shard := *o.Shard
+		c.Shard = &shard
+	}
+
+	if o.Metadata != nil {
+		c.Metadata = make(map[string]string, len(o.Metadata))
+		for k, v := range o.Metadata {
+			c.Metadata[k] = v
+		}
+	}
+
+	if o.HealthCheck != nil {
+		hc := *o.HealthCheck
+		c.HealthCheck = &hc
+	}
+
+	return c
 }
 
-func (ss *ServerSet) registerEndpoint(connection *zk.Conn, data []byte) (string, error) {
+func (ss *ServerSet) registerEndpoint(connection Backend, data []byte) (string, error) {
 	err := ss.createFullPath(connection)
 	if err != nil {
 		return "", err
 	}
 
-	return connection.Create(
-		ss.directoryPath()+"/"+MemberPrefix,
-		data,
-		zk.FlagEphemeral|zk.FlagSequence,
-		zk.WorldACL(zk.PermAll))
+	return connection.CreateMember(ss.directoryPath()+"/"+MemberPrefix, data, ss.ACL)
 }
@@ -1,13 +1,16 @@
 package serversets
 
 import (
+	"context"
 	"errors"
+	"math/rand"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
 func TestEndpointSameName(t *testing.T) {
-	set := New(Test, "gotest", []string{TestServer})
+	set := newTestSet()
 	watch, err := set.Watch()
 	if err != nil {
 		panic(err)
@@ -37,7 +40,7 @@ func TestEndpointSameName(t *testing.T) {
 }
 
 func TestEndpointPingInitiallyUp(t *testing.T) {
-	set := New(Test, "gotest", []string{TestServer})
+	set := newTestSet()
 	watch, err := set.Watch()
 	if err != nil {
 		panic(err)
@@ -82,7 +85,7 @@ func TestEndpointPingInitiallyUp(t *testing.T) {
 }
 
 func TestEndpointPingInitiallyDown(t *testing.T) {
-	set := New(Test, "gotest", []string{TestServer})
+	set := newTestSet()
 	watch, err := set.Watch()
 	if err != nil {
 		panic(err)
@@ -128,7 +131,7 @@ func TestEndpointPingInitiallyDown(t *testing.T) {
 }
 
 func TestEndpointClosePingRoutine(t *testing.T) {
-	set := New(Test, "gotest", []string{TestServer})
+	set := newTestSet()
 
 	ping := 0
 	ep, err := set.RegisterEndpoint("localhost", 1, func() error {
@@ -149,7 +152,7 @@ func TestEndpointClosePingRoutine(t *testing.T) {
 }
 
 func TestEndpointMultipleClose(t *testing.T) {
-	set := New(Test, "gotest", []string{TestServer})
+	set := newTestSet()
 
 	ep, err := set.RegisterEndpoint("localhost", 1, nil)
 	if err != nil {
@@ -161,3 +164,437 @@ func TestEndpointMultipleClose(t *testing.T) {
 	ep.Close()
 	ep.Close()
 }
+
+func TestEndpointWithOptions(t *testing.T) {
+	set := newTestSet()
+	watch, err := set.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch.Close()
+
+	shard := 5
+	options := EndpointOptions{
+		AdditionalEndpoints: map[string]MemberEndpoint{"admin": {"localhost", 9990}},
+		Shard:               &shard,
+		Metadata:            map[string]string{"version": "1.2"},
+	}
+
+	ep, err := set.RegisterEndpointWithOptions("localhost", 1, nil, options)
+	if err != nil {
+		t.Fatalf("registration failure: %v", err)
+	}
+	defer ep.Close()
+
+	// changes after registering should not matter
+	options.Metadata["version"] = "2.0"
+	shard = 6
+
+	<-watch.Event()
+
+	members := watch.Members()
+	if len(members) != 1 {
+		t.Fatalf("should have one member, got %v", members)
+	}
+
+	m := members[0]
+	if v := m.AdditionalEndpoints["admin"].String(); v != "localhost:9990" {
+		t.Errorf("incorrect admin endpoint, got %v", v)
+	}
+
+	if m.Shard == nil || *m.Shard != 5 {
+		t.Errorf("incorrect shard, got %v", m.Shard)
+	}
+
+	if v := m.Metadata["version"]; v != "1.2" {
+		t.Errorf("incorrect metadata, got %v", v)
+	}
+}
+
+func TestEndpointReconnect(t *testing.T) {
+	set, dialer := newUnreliableSet()
+
+	watch, err := set.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch.Close()
+
+	ep, err := set.RegisterEndpoint("localhost", 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Close()
+	<-watch.Event()
+
+	dialer.SetDown(true)
+
+	if err := <-ep.Errors(); err == nil {
+		t.Errorf("should report reconnect error")
+	}
+
+	if s := ep.Registration(); s != EndpointReconnecting {
+		t.Errorf("should be reconnecting, got %v", s)
+	}
+
+	if s := <-ep.StateChanges(); s != StateExpired {
+		t.Errorf("should be expired, got %v", s)
+	}
+
+	dialer.SetDown(false)
+
+	if s := <-ep.StateChanges(); s != StateReregistered {
+		t.Errorf("should be reregistered, got %v", s)
+	}
+
+	if s := ep.State(); s != StateReregistered {
+		t.Errorf("should be reregistered, got %v", s)
+	}
+
+	if s := ep.Registration(); s != EndpointRegistered {
+		t.Errorf("should be registered, got %v", s)
+	}
+
+	for {
+		<-watch.Event()
+
+		members := watch.Members()
+		if len(members) == 1 && members[0].Sequence == 1 {
+			break
+		}
+	}
+}
+
+func TestEndpointErrorsClosed(t *testing.T) {
+	set := newTestSet()
+
+	ep, err := set.RegisterEndpoint("localhost", 1, nil)
+	if err != nil {
+		t.Fatalf("registration failure: %v", err)
+	}
+
+	ep.Close()
+
+	if _, ok := <-ep.Errors(); ok {
+		t.Errorf("errors channel should be closed")
+	}
+}
+
+func TestEndpointRegistration(t *testing.T) {
+	set := newTestSet()
+
+	var healthy int32 = 1
+	ep, err := set.RegisterEndpointWithOptions("localhost", 1, nil, EndpointOptions{
+		HealthCheck: &HealthCheck{
+			Check: func(ctx context.Context) error {
+				if atomic.LoadInt32(&healthy) == 0 {
+					return errors.New("unhealthy")
+				}
+				return nil
+			},
+			Interval: time.Millisecond,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := ep.Registration(); s != EndpointRegistered {
+		t.Errorf("should be registered, got %v", s)
+	}
+
+	atomic.StoreInt32(&healthy, 0)
+	for ep.Registration() != EndpointUnhealthy {
+		time.Sleep(time.Millisecond)
+	}
+
+	ep.Close()
+	if s := ep.Registration(); s != EndpointClosed {
+		t.Errorf("should be closed, got %v", s)
+	}
+}
+
+// run with -race, health changes and session expirations race to update the member.
+func TestEndpointConcurrentUpdates(t *testing.T) {
+	set, dialer := newUnreliableSet()
+
+	ep, err := set.RegisterEndpointWithOptions("localhost", 1, nil, EndpointOptions{
+		HealthCheck: &HealthCheck{
+			Check: func(ctx context.Context) error {
+				if rand.Intn(2) == 0 {
+					return errors.New("unhealthy")
+				}
+				return nil
+			},
+			Interval: time.Millisecond,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 20; i++ {
+		dialer.ExpireSessions()
+		ep.Registration()
+		ep.State()
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	ep.Close()
+
+	conn, _ := dialer.Dial()
+	defer conn.Close()
+
+	children, _, err := conn.ChildrenW(set.directoryPath())
+	if err != nil && err != ErrNoNode {
+		t.Fatal(err)
+	}
+
+	if len(children) != 0 {
+		t.Errorf("should remove member on close, got %v", children)
+	}
+}
+
+func TestEndpointDrain(t *testing.T) {
+	set := newTestSet()
+	set.DrainGracePeriod = 100 * time.Millisecond
+
+	watch, err := set.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch.Close()
+
+	ep, err := set.RegisterEndpoint("localhost", 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-watch.Event()
+
+	drained := make(chan error)
+	go func() {
+		drained <- ep.Drain(context.Background())
+	}()
+
+	<-watch.Event()
+	if len(watch.Endpoints()) != 0 {
+		t.Errorf("should drop stopping endpoint, got %v", watch.Endpoints())
+	}
+
+	members := watch.Members()
+	if len(members) != 1 || members[0].Status != StatusStopping {
+		t.Fatalf("should keep member with stopping status, got %v", members)
+	}
+
+	if members[0].Sequence != 0 {
+		t.Errorf("should update member in place, got %v", members[0].Name)
+	}
+
+	if s := ep.Registration(); s != EndpointDraining {
+		t.Errorf("should be draining, got %v", s)
+	}
+
+	if err := <-drained; err != nil {
+		t.Errorf("should drain, got %v", err)
+	}
+
+	<-watch.Event()
+	if len(watch.Members()) != 0 {
+		t.Errorf("should remove member, got %v", watch.Members())
+	}
+
+	if s := ep.Registration(); s != EndpointClosed {
+		t.Errorf("should be closed, got %v", s)
+	}
+}
+
+func TestEndpointDrainContext(t *testing.T) {
+	set := newTestSet()
+	set.DrainGracePeriod = time.Hour
+
+	ep, err := set.RegisterEndpoint("localhost", 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := ep.Drain(ctx); err != context.DeadlineExceeded {
+		t.Errorf("should stop waiting when the context is done, got %v", err)
+	}
+
+	if s := ep.Registration(); s != EndpointClosed {
+		t.Errorf("should be closed, got %v", s)
+	}
+
+	// already closed
+	if err := ep.Drain(context.Background()); err != nil {
+		t.Errorf("should not fail once closed, got %v", err)
+	}
+}
+
+func TestEndpointFlapInPlace(t *testing.T) {
+	set := newTestSet()
+
+	watch, err := set.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch.Close()
+
+	var healthy int32 = 1
+	ep, err := set.RegisterEndpointWithOptions("localhost", 1, nil, EndpointOptions{
+		HealthCheck: &HealthCheck{
+			Check: func(ctx context.Context) error {
+				if atomic.LoadInt32(&healthy) == 0 {
+					return errors.New("unhealthy")
+				}
+				return nil
+			},
+			Interval: time.Millisecond,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Close()
+	<-watch.Event()
+
+	atomic.StoreInt32(&healthy, 0)
+	<-watch.Event()
+
+	members := watch.Members()
+	if len(members) != 1 || members[0].Status != StatusWarning {
+		t.Fatalf("should keep member with warning status, got %v", members)
+	}
+
+	if len(watch.Endpoints()) != 0 {
+		t.Errorf("should drop unhealthy endpoint, got %v", watch.Endpoints())
+	}
+
+	atomic.StoreInt32(&healthy, 1)
+	<-watch.Event()
+
+	members = watch.Members()
+	if len(members) != 1 || members[0].Status != StatusAlive {
+		t.Fatalf("should set status back to alive, got %v", members)
+	}
+
+	if members[0].Sequence != 0 {
+		t.Errorf("should keep the same member, got %v", members[0].Name)
+	}
+}
+
+func TestEndpointSetStatus(t *testing.T) {
+	set := newTestSet()
+
+	watch, err := set.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch.Close()
+
+	ep, err := set.RegisterEndpointWithOptions("localhost", 1, nil, EndpointOptions{
+		Metadata: map[string]string{"version": "1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-watch.Event()
+
+	if err := ep.SetStatus(StatusWarning); err != nil {
+		t.Fatalf("should set status, got %v", err)
+	}
+	<-watch.Event()
+
+	members := watch.Members()
+	if len(members) != 1 || members[0].Status != StatusWarning {
+		t.Fatalf("should update status, got %v", members)
+	}
+
+	if err := ep.SetMetadata(map[string]string{"version": "2"}); err != nil {
+		t.Fatalf("should set metadata, got %v", err)
+	}
+	<-watch.Event()
+
+	members = watch.Members()
+	if len(members) != 1 || members[0].Metadata["version"] != "2" {
+		t.Fatalf("should update metadata, got %v", members)
+	}
+
+	if members[0].Status != StatusWarning || members[0].Sequence != 0 {
+		t.Errorf("should update member in place, got %v", members[0])
+	}
+
+	ep.Close()
+	if err := ep.SetStatus(StatusAlive); err != ErrEndpointClosed {
+		t.Errorf("should fail once closed, got %v", err)
+	}
+}
+
+// flakyBackend fails the next SetMember calls, while keeping the session.
+type flakyBackend struct {
+	Backend
+	failures *int32
+}
+
+func (b *flakyBackend) SetMember(path string, data []byte) error {
+	if atomic.AddInt32(b.failures, -1) >= 0 {
+		return errors.New("zk: connection closed")
+	}
+
+	return b.Backend.SetMember(path, data)
+}
+
+func TestEndpointUpdateRetry(t *testing.T) {
+	store := NewMemoryStore()
+
+	var failures int32
+	set := NewWithDialer(Test, "gotest", func() (Backend, error) {
+		b, err := store.Dial()
+		if err != nil {
+			return nil, err
+		}
+
+		return &flakyBackend{Backend: b, failures: &failures}, nil
+	})
+	set.RetryDelay = time.Millisecond
+
+	ep, err := set.RegisterEndpoint("localhost", 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Close()
+
+	name := ep.MemberName()
+
+	atomic.StoreInt32(&failures, 1)
+	if err := ep.SetStatus(StatusWarning); err == nil {
+		t.Errorf("should return the update error")
+	}
+
+	watch, err := set.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch.Close()
+
+	timeout := time.After(time.Second)
+	for {
+		members := watch.Members()
+		if len(members) == 1 && members[0].Status == StatusWarning {
+			break
+		}
+
+		select {
+		case <-watch.Event():
+		case <-timeout:
+			t.Fatalf("should retry the update, got %v", members)
+		}
+	}
+
+	if n := ep.MemberName(); n != name {
+		t.Errorf("should keep the member on a transient error, got %v, was %v", n, name)
+	}
+}
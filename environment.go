@@ -0,0 +1,391 @@
+package serversets
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// environmentPath returns the directory holding the directories of all the services
+// of the environment, the parent of the BaseZnodePath of its services.
+func environmentPath(environment Environment) string {
+	return path.Dir(BaseZnodePath(environment, "service"))
+}
+
+// ListServices returns the sorted names of the services with a directory in the environment.
+// Services that never had an endpoint or watch may not have one.
+func (c *Client) ListServices(environment Environment) ([]string, error) {
+	connection, err := c.Dial()
+	if err != nil {
+		return nil, err
+	}
+	defer connection.Close()
+
+	services, err := connection.Children(environmentPath(environment))
+	if err == ErrNoNode {
+		return []string{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(services)
+	return services, nil
+}
+
+// A ServiceEvent describes services appearing or disappearing in an EnvironmentWatch.
+// If events are not read as fast as they happen they are merged, so Added and Removed
+// are always relative to the Services of the previous event read from the channel.
+type ServiceEvent struct {
+	Added   []string
+	Removed []string
+
+	// Services is the full new list of services.
+	Services []string
+
+	// the services this event is relative to, used for merging.
+	previous []string
+}
+
+func newServiceEvent(previous, current []string) ServiceEvent {
+	added, removed := diffEndpoints(previous, current)
+	return ServiceEvent{
+		Added:    added,
+		Removed:  removed,
+		Services: current,
+		previous: previous,
+	}
+}
+
+// sendServiceEvent sends the event to the channel, which must only have this one sender.
+// If the channel is full, all pending events are merged with this one, same as sendChange.
+func sendServiceEvent(changes chan ServiceEvent, event ServiceEvent) {
+	sendMerged(
+		func() bool {
+			select {
+			case changes <- event:
+				return true
+			default:
+				return false
+			}
+		},
+		func() ([]string, bool) {
+			select {
+			case pending := <-changes:
+				return pending.previous, true
+			default:
+				return nil, false
+			}
+		},
+		func(previous []string) {
+			event = newServiceEvent(previous, event.Services)
+		})
+}
+
+// An EnvironmentWatch watches every service of an environment, services appearing
+// and disappearing, and the members of each. Like Watch, it counts its events
+// in EventCount and LastEvent.
+type EnvironmentWatch struct {
+	aggregator
+	changes chan ServiceEvent
+
+	client      *Client
+	environment Environment
+
+	// lock for read/writing the services, watches and members
+	lock     sync.RWMutex
+	services []string
+	watches  map[string]*Watch
+	members  map[string][]Member
+}
+
+// WatchEnvironment watches all the services of the environment, on the shared session
+// of the client. Each service is watched like ServerSet.Watch, as they appear.
+func (c *Client) WatchEnvironment(environment Environment) (*EnvironmentWatch, error) {
+	ew := &EnvironmentWatch{
+		aggregator:  newAggregator(),
+		changes:     make(chan ServiceEvent, 1),
+		client:      c,
+		environment: environment,
+		services:    []string{},
+		watches:     make(map[string]*Watch),
+		members:     make(map[string][]Member),
+	}
+
+	connection, watchEvents, services, err := ew.refresh(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// the initial services are not sent as a change
+	ew.services = services
+	_, synced := ew.sync(services)
+	ew.merge()
+
+	ew.wg.Add(1)
+	go ew.run(connection, watchEvents, services, synced)
+
+	return ew, nil
+}
+
+// run deals with session expirations and changes to the list of services,
+// until closed. On failure the current services are kept until a retry succeeds.
+func (ew *EnvironmentWatch) run(connection Backend, watchEvents <-chan struct{}, services []string, synced bool) {
+	defer ew.wg.Done()
+
+	backoff := newBackoff(ew.client.RetryDelay, ew.client.MaxRetryDelay)
+	var retry <-chan time.Time
+	if !synced {
+		retry = time.After(backoff.next())
+	}
+
+	for {
+		var sessionEvents <-chan SessionEvent
+		if connection != nil {
+			sessionEvents = connection.SessionEvents()
+		}
+
+		select {
+		case event := <-sessionEvents:
+			if event.State != SessionExpired {
+				continue
+			}
+
+			connection.Close()
+			connection = nil
+			watchEvents = nil
+		case <-watchEvents:
+			watchEvents = nil
+		case <-retry:
+			retry = nil
+		case <-ew.done:
+			if connection != nil {
+				connection.Close()
+			}
+
+			// closes the watches of all the services
+			ew.sync(nil)
+			return
+		}
+
+		if retry != nil {
+			// already failing, wait for the retry to rewatch
+			continue
+		}
+
+		var err error
+		connection, watchEvents, services, err = ew.refresh(connection, watchEvents)
+		if err != nil {
+			reportError(ew.errs, err)
+			retry = time.After(backoff.next())
+			continue
+		}
+
+		changed := ew.setServices(services)
+		watched, synced := ew.sync(services)
+		if changed || watched {
+			ew.update(ew.merge)
+		}
+
+		if !synced {
+			// some services could not be watched
+			retry = time.After(backoff.next())
+			continue
+		}
+
+		backoff.reset()
+	}
+}
+
+// refresh reconnects and rewatches the list of services, if necessary.
+// If still watching, the services are the ones of that watch.
+// On error the connection is closed, and nil returned, so the next attempt starts over.
+func (ew *EnvironmentWatch) refresh(connection Backend, watchEvents <-chan struct{}) (Backend, <-chan struct{}, []string, error) {
+	var err error
+	if connection == nil {
+		connection, err = ew.client.Dial()
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("unable to reconnect to zookeeper: %v", err)
+		}
+	}
+
+	if watchEvents != nil {
+		return connection, watchEvents, ew.Services(), nil
+	}
+
+	p := environmentPath(ew.environment)
+	err = connection.CreatePath(p, ew.client.ACL)
+	if err != nil {
+		connection.Close()
+		return nil, nil, nil, fmt.Errorf("unable to rewatch services: %v", err)
+	}
+
+	services, watchEvents, err := connection.ChildrenW(p)
+	if err != nil {
+		connection.Close()
+		return nil, nil, nil, fmt.Errorf("unable to rewatch services: %v", err)
+	}
+	sort.Strings(services)
+
+	return connection, watchEvents, services, nil
+}
+
+// setServices updates the list of services and sends the change, if any.
+func (ew *EnvironmentWatch) setServices(services []string) bool {
+	ew.lock.Lock()
+	previous := ew.services
+	ew.services = services
+	ew.lock.Unlock()
+
+	event := newServiceEvent(previous, services)
+	if len(event.Added) == 0 && len(event.Removed) == 0 {
+		return false
+	}
+
+	sendServiceEvent(ew.changes, event)
+	return true
+}
+
+// sync starts watching the new services and closes the watches of the removed ones.
+// Returns if any watch was started or closed, and false if some services could not be watched.
+func (ew *EnvironmentWatch) sync(services []string) (changed, synced bool) {
+	current := make(map[string]struct{}, len(services))
+	for _, s := range services {
+		current[s] = struct{}{}
+	}
+
+	ew.lock.Lock()
+	var removed []*Watch
+	for name, watch := range ew.watches {
+		if _, ok := current[name]; !ok {
+			delete(ew.watches, name)
+			removed = append(removed, watch)
+		}
+	}
+
+	var added []string
+	for _, s := range services {
+		if _, ok := ew.watches[s]; !ok {
+			added = append(added, s)
+		}
+	}
+	ew.lock.Unlock()
+
+	// closing the watch also ends its forwarding goroutine
+	for _, watch := range removed {
+		watch.Close()
+	}
+
+	synced = true
+	for _, name := range added {
+		ss := ew.client.ServerSet(ew.environment, name)
+		ss.watchExisting = true
+
+		watch, err := ss.Watch()
+		if err != nil {
+			reportError(ew.errs, fmt.Errorf("%s: %v", name, err))
+			synced = false
+			continue
+		}
+
+		sub := watch.Subscribe(1)
+
+		ew.lock.Lock()
+		ew.watches[name] = watch
+		ew.lock.Unlock()
+
+		ew.wg.Add(1)
+		go ew.forward(name, watch, sub)
+		changed = true
+	}
+
+	return changed || len(removed) > 0, synced
+}
+
+// forward merges the members of the service whenever they change,
+// and reports its errors, until its watch is closed.
+func (ew *EnvironmentWatch) forward(name string, watch *Watch, sub *Subscription) {
+	defer ew.wg.Done()
+
+	for {
+		select {
+		case _, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			ew.update(ew.merge)
+		case err, ok := <-watch.Errors():
+			if !ok {
+				return
+			}
+			reportError(ew.errs, fmt.Errorf("%s: %v", name, err))
+		}
+	}
+}
+
+// Services returns the sorted names of the services in the environment.
+func (ew *EnvironmentWatch) Services() []string {
+	ew.lock.RLock()
+	defer ew.lock.RUnlock()
+
+	return ew.services
+}
+
+// Members returns the members of every service, keyed by service name.
+// Services that could not be watched yet are missing.
+func (ew *EnvironmentWatch) Members() map[string][]Member {
+	ew.lock.RLock()
+	defer ew.lock.RUnlock()
+
+	return ew.members
+}
+
+// Watch returns the watch of a single service, nil if it's not watched.
+// It can be used with httpset, mcset and thriftset, but must not be closed directly.
+func (ew *EnvironmentWatch) Watch(service string) *Watch {
+	ew.lock.RLock()
+	defer ew.lock.RUnlock()
+
+	return ew.watches[service]
+}
+
+// Event returns the event channel. This channel will get an object
+// whenever a service appears or disappears, or its members change.
+func (ew *EnvironmentWatch) Event() <-chan struct{} {
+	return ew.event
+}
+
+// ServiceChanges returns the channel of services appearing or disappearing.
+// The services when the watch was created are not sent, see Services.
+func (ew *EnvironmentWatch) ServiceChanges() <-chan ServiceEvent {
+	return ew.changes
+}
+
+// Errors returns a channel that gets the errors of watching the list of services,
+// and of each service, prefixed with the service name. Errors are dropped if the channel is not read.
+func (ew *EnvironmentWatch) Errors() <-chan error {
+	return ew.errs
+}
+
+// Close closes the watches of all the services.
+func (ew *EnvironmentWatch) Close() {
+	ew.shutdown(func() {
+		close(ew.changes)
+	})
+}
+
+// merge updates the members from the watches of all the services.
+func (ew *EnvironmentWatch) merge() {
+	ew.lock.Lock()
+	defer ew.lock.Unlock()
+
+	members := make(map[string][]Member, len(ew.watches))
+	for name, watch := range ew.watches {
+		members[name] = watch.Members()
+	}
+
+	ew.members = members
+}
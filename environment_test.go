@@ -0,0 +1,171 @@
+package serversets
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestClientListServices(t *testing.T) {
+	client := NewClientWithDialer(NewMemoryStore().Dial)
+
+	services, err := client.ListServices(Test)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(services) != 0 {
+		t.Errorf("should have no services, got %v", services)
+	}
+
+	for _, name := range []string{"gotest2", "gotest1"} {
+		ep, err := client.ServerSet(Test, name).RegisterEndpoint("localhost", 1, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ep.Close()
+	}
+
+	services, err = client.ListServices(Test)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(services, []string{"gotest1", "gotest2"}) {
+		t.Errorf("should list sorted services, got %v", services)
+	}
+
+	services, err = client.ListServices(Production)
+	if err != nil || len(services) != 0 {
+		t.Errorf("should have no services in another environment, got %v %v", services, err)
+	}
+}
+
+func TestEnvironmentWatch(t *testing.T) {
+	store := NewMemoryStore()
+	client := NewClientWithDialer(store.Dial)
+
+	ep1, err := client.ServerSet(Test, "gotest1").RegisterEndpoint("localhost", 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep1.Close()
+
+	watch, err := client.WatchEnvironment(Test)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch.Close()
+
+	if s := watch.Services(); !reflect.DeepEqual(s, []string{"gotest1"}) {
+		t.Errorf("should have existing service, got %v", s)
+	}
+
+	if m := watch.Members()["gotest1"]; len(m) != 1 {
+		t.Errorf("should have members of existing service, got %v", m)
+	}
+
+	// a new service
+	ep2, err := client.ServerSet(Test, "gotest2").RegisterEndpoint("localhost", 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := <-watch.ServiceChanges()
+	if !reflect.DeepEqual(event.Added, []string{"gotest2"}) || len(event.Removed) != 0 {
+		t.Errorf("should add service, got %v", event)
+	}
+
+	if !reflect.DeepEqual(event.Services, []string{"gotest1", "gotest2"}) {
+		t.Errorf("should have all services, got %v", event.Services)
+	}
+
+	for len(watch.Members()["gotest2"]) != 1 {
+		<-watch.Event()
+	}
+
+	if w := watch.Watch("gotest2"); w == nil || !reflect.DeepEqual(w.Endpoints(), []string{"localhost:2"}) {
+		t.Errorf("should watch new service, got %v", w)
+	}
+
+	// members of an existing service
+	ep2.Close()
+	for len(watch.Members()["gotest2"]) != 0 {
+		<-watch.Event()
+	}
+
+	// removing the service directory
+	conn, err := store.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.DeleteMember(BaseZnodePath(Test, "gotest2")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case event = <-watch.ServiceChanges():
+	case <-time.After(time.Second):
+		t.Fatalf("should remove service")
+	}
+
+	if !reflect.DeepEqual(event.Removed, []string{"gotest2"}) || len(event.Added) != 0 {
+		t.Errorf("should remove service, got %v", event)
+	}
+
+	for {
+		if _, ok := watch.Members()["gotest2"]; !ok {
+			break
+		}
+		<-watch.Event()
+	}
+
+	if w := watch.Watch("gotest2"); w != nil {
+		t.Errorf("should close watch of removed service")
+	}
+
+	// stays removed
+	time.Sleep(10 * time.Millisecond)
+	if s := watch.Services(); !reflect.DeepEqual(s, []string{"gotest1"}) {
+		t.Errorf("should not recreate removed service, got %v", s)
+	}
+}
+
+func TestServiceEventMerge(t *testing.T) {
+	changes := make(chan ServiceEvent, 1)
+
+	sendServiceEvent(changes, newServiceEvent([]string{"a"}, []string{"a", "b"}))
+	sendServiceEvent(changes, newServiceEvent([]string{"a", "b"}, []string{"b", "c"}))
+
+	event := <-changes
+	if !reflect.DeepEqual(event.Added, []string{"b", "c"}) || !reflect.DeepEqual(event.Removed, []string{"a"}) {
+		t.Errorf("should merge events, got %v", event)
+	}
+}
+
+func TestEnvironmentWatchClientOptions(t *testing.T) {
+	store := NewMemoryStore()
+	client := NewClientWithDialer(store.Dial)
+	client.ACL = DigestACL(PermAll, "user", "password")
+	client.RetryDelay = time.Millisecond
+
+	if ss := client.ServerSet(Test, "gotest"); ss.RetryDelay != time.Millisecond || !reflect.DeepEqual(ss.ACL, client.ACL) {
+		t.Errorf("server sets should use the client options, got %v %v", ss.RetryDelay, ss.ACL)
+	}
+
+	watch, err := client.WatchEnvironment(Test)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch.Close()
+
+	store.lock.Lock()
+	acl := store.nodes[environmentPath(Test)].acl
+	store.lock.Unlock()
+
+	if !reflect.DeepEqual(acl, client.ACL) {
+		t.Errorf("should create the environment directory with the client ACL, got %v", acl)
+	}
+}
@@ -0,0 +1,129 @@
+package serversets
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// A HealthCheck configures how an endpoint checks it's healthy, see EndpointOptions.
+// The member status is WARNING while unhealthy.
+type HealthCheck struct {
+	// Check is the health check, it should return once the context is done.
+	// If nil, the ping function given when registering is used.
+	Check func(ctx context.Context) error
+
+	// Interval between checks, the ping rate of the endpoint if not set, see Endpoint.SetPingRate.
+	Interval time.Duration
+
+	// Jitter is a random delay, up to this duration, added to every interval
+	// so the checks of many endpoints don't run in lockstep.
+	Jitter time.Duration
+
+	// Timeout of each check, a check that takes longer fails. Zero means no timeout.
+	Timeout time.Duration
+
+	// Rise and Fall are the number of consecutive successes, or failures, needed
+	// to become healthy, or unhealthy. Default 1. The first check, when registering,
+	// decides the initial health on its own.
+	Rise int
+	Fall int
+}
+
+// healthChecker runs a health check and keeps track of the consecutive results.
+type healthChecker struct {
+	HealthCheck
+
+	alive     bool
+	successes int
+	failures  int
+}
+
+func newHealthChecker(hc HealthCheck, ping func() error) *healthChecker {
+	if hc.Check == nil {
+		hc.Check = pingCheck(ping)
+	}
+
+	if hc.Rise < 1 {
+		hc.Rise = 1
+	}
+
+	if hc.Fall < 1 {
+		hc.Fall = 1
+	}
+
+	return &healthChecker{HealthCheck: hc}
+}
+
+// pingCheck makes a ping function context aware, it's abandoned if the context is done first.
+// A hanging ping is not started again, the following checks wait for it and fail
+// until it returns. Must not be called concurrently.
+func pingCheck(ping func() error) func(ctx context.Context) error {
+	var running chan error
+	return func(ctx context.Context) error {
+		if running == nil {
+			running = make(chan error, 1)
+			go func(result chan error) {
+				result <- ping()
+			}(running)
+		}
+
+		select {
+		case err := <-running:
+			running = nil
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// first runs the first check, which sets the health without waiting for the thresholds.
+func (c *healthChecker) first(ctx context.Context) bool {
+	c.alive = c.run(ctx) == nil
+	return c.alive
+}
+
+// check runs the health check and returns if healthy, once the rise or fall threshold is reached.
+func (c *healthChecker) check(ctx context.Context) bool {
+	if c.run(ctx) == nil {
+		c.successes++
+		c.failures = 0
+
+		if !c.alive && c.successes >= c.Rise {
+			c.alive = true
+		}
+	} else {
+		c.failures++
+		c.successes = 0
+
+		if c.alive && c.failures >= c.Fall {
+			c.alive = false
+		}
+	}
+
+	return c.alive
+}
+
+func (c *healthChecker) run(ctx context.Context) error {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	return c.Check(ctx)
+}
+
+// next returns the delay until the next check, the interval is used if none is configured.
+func (c *healthChecker) next(interval time.Duration) time.Duration {
+	if c.Interval > 0 {
+		interval = c.Interval
+	}
+
+	if c.Jitter <= 0 {
+		return interval
+	}
+
+	return interval + time.Duration(rand.Int63n(int64(c.Jitter)))
+}
@@ -0,0 +1,184 @@
+package serversets
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthCheckerThresholds(t *testing.T) {
+	var err error
+	c := newHealthChecker(HealthCheck{Rise: 2, Fall: 3}, func() error { return err })
+
+	if !c.first(context.Background()) {
+		t.Fatalf("should be healthy")
+	}
+
+	err = errors.New("down")
+	for i := 0; i < 2; i++ {
+		if !c.check(context.Background()) {
+			t.Errorf("should be healthy until the fall threshold, check %d", i)
+		}
+	}
+
+	if c.check(context.Background()) {
+		t.Errorf("should be unhealthy after the fall threshold")
+	}
+
+	err = nil
+	if c.check(context.Background()) {
+		t.Errorf("should be unhealthy until the rise threshold")
+	}
+
+	if !c.check(context.Background()) {
+		t.Errorf("should be healthy after the rise threshold")
+	}
+}
+
+func TestHealthCheckerTimeout(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	c := newHealthChecker(HealthCheck{Timeout: 10 * time.Millisecond}, func() error {
+		<-block
+		return nil
+	})
+
+	if c.first(context.Background()) {
+		t.Errorf("should fail a hanging ping")
+	}
+
+	c = newHealthChecker(HealthCheck{
+		Timeout: 10 * time.Millisecond,
+		Check: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}, nil)
+
+	if c.first(context.Background()) {
+		t.Errorf("should fail a hanging check")
+	}
+}
+
+func TestHealthCheckerNext(t *testing.T) {
+	c := newHealthChecker(HealthCheck{Jitter: 10 * time.Millisecond}, func() error { return nil })
+
+	for i := 0; i < 100; i++ {
+		if d := c.next(time.Second); d < time.Second || d >= time.Second+10*time.Millisecond {
+			t.Fatalf("delay out of range, got %v", d)
+		}
+	}
+
+	// the ping rate can change between checks
+	c = newHealthChecker(HealthCheck{}, func() error { return nil })
+	if d := c.next(10 * time.Millisecond); d != 10*time.Millisecond {
+		t.Errorf("should use the given interval, got %v", d)
+	}
+
+	c = newHealthChecker(HealthCheck{Interval: time.Second}, func() error { return nil })
+	if d := c.next(10 * time.Millisecond); d != time.Second {
+		t.Errorf("should use the configured interval, got %v", d)
+	}
+}
+
+func TestHealthCheckerHangingPing(t *testing.T) {
+	block := make(chan struct{})
+
+	var pings int32
+	c := newHealthChecker(HealthCheck{Timeout: 10 * time.Millisecond}, func() error {
+		atomic.AddInt32(&pings, 1)
+		<-block
+		return nil
+	})
+
+	for i := 0; i < 10; i++ {
+		if c.check(context.Background()) {
+			t.Errorf("should fail while the ping hangs, check %d", i)
+		}
+	}
+
+	if p := atomic.LoadInt32(&pings); p != 1 {
+		t.Errorf("should only have one ping in flight, got %d", p)
+	}
+
+	close(block)
+	if !c.check(context.Background()) {
+		t.Errorf("should be healthy once the ping returns")
+	}
+
+	if !c.check(context.Background()) || atomic.LoadInt32(&pings) != 2 {
+		t.Errorf("should ping again, got %d pings", atomic.LoadInt32(&pings))
+	}
+}
+
+func TestEndpointHealthCheck(t *testing.T) {
+	set := newTestSet()
+	watch, err := set.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch.Close()
+
+	healthy := make(chan bool, 1)
+	healthy <- false
+
+	ep, err := set.RegisterEndpointWithOptions("localhost", 1, nil, EndpointOptions{
+		HealthCheck: &HealthCheck{
+			Check: func(ctx context.Context) error {
+				h := <-healthy
+				healthy <- h
+				if !h {
+					return errors.New("unhealthy")
+				}
+				return nil
+			},
+			Interval: time.Millisecond,
+			Rise:     2,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Close()
+
+	if len(watch.Endpoints()) != 0 {
+		t.Errorf("should not register unhealthy endpoint, got %v", watch.Endpoints())
+	}
+
+	<-healthy
+	healthy <- true
+
+	<-watch.Event()
+	if len(watch.Endpoints()) != 1 {
+		t.Errorf("should register once healthy, got %v", watch.Endpoints())
+	}
+}
+
+func TestEndpointSetPingRate(t *testing.T) {
+	set := newTestSet()
+
+	var pings int32
+	ep, err := set.RegisterEndpoint("localhost", 1, func() error {
+		atomic.AddInt32(&pings, 1)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Close()
+
+	// the first check is still a second away
+	ep.SetPingRate(5 * time.Millisecond)
+
+	timeout := time.After(3 * time.Second)
+	for atomic.LoadInt32(&pings) < 5 {
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-timeout:
+			t.Fatalf("should use the new ping rate, got %d pings", atomic.LoadInt32(&pings))
+		}
+	}
+}
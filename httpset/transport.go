@@ -2,12 +2,16 @@ package httpset
 
 import (
 	"net/http"
+	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/strava/go.serversets"
 )
 
 // Transport implements the http.RoundTripper interface loadbalancing
-// over a set of hosts.
+// over a set of hosts. If the watch has the weights of the endpoints,
+// like serversets.Watch, the round-robin is weighted.
 type Transport struct {
 	Watcher
 
@@ -20,9 +24,14 @@ type Transport struct {
 	LastEvent  time.Time
 	EventCount int
 
-	event     chan struct{}
-	count     int64
+	event chan struct{}
+	count int64
+
+	// guards the endpoints and locality, set by the watch goroutine and the callers
+	lock      sync.Mutex
 	endpoints []string
+	weighted  *smoothWeighted // nil if all the endpoints have the same weight
+	locality  *serversets.Locality
 }
 
 // NewTransport creates a new Transport given the server set.
@@ -35,13 +44,14 @@ func NewTransport(watch Watcher) *Transport {
 
 	if watch != nil {
 		// don't trigger an event the first time
-		t.setEndpoints(watch.Endpoints())
+		t.setEndpoints(t.watchEndpoints(watch))
+		events, stop := serversets.WatcherEvents(watch)
 
 		go func() {
 			for {
 				select {
-				case <-watch.Event():
-					t.SetEndpoints(watch.Endpoints())
+				case <-events:
+					t.SetWeightedEndpoints(t.watchEndpoints(watch))
 				}
 
 				if watch.IsClosed() {
@@ -49,6 +59,7 @@ func NewTransport(watch Watcher) *Transport {
 				}
 			}
 
+			stop()
 			watcherClosed()
 		}()
 	}
@@ -97,6 +108,9 @@ func (t *Transport) replaceHost(req *http.Request) error {
 // Endpoints returns the current endpoints for this service.
 // This can be those set via the serverset.Watch or manually via SetEndpoints()
 func (t *Transport) Endpoints() []string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
 	return t.endpoints
 }
 
@@ -111,30 +125,143 @@ func (t *Transport) Event() <-chan struct{} {
 // returned by the serverset. An event by the serverset will override these values.
 // This should be used to take advantage of the round robin features of this library without a serverset.Watch.
 func (t *Transport) SetEndpoints(endpoints []string) {
-	t.setEndpoints(endpoints)
+	t.SetWeightedEndpoints(endpoints, nil)
+}
+
+// SetWeightedEndpoints sets the current list of endpoints, same as SetEndpoints,
+// and the weight of each. Endpoints missing from the weights, or with a weight
+// less than 1, have a weight of 1.
+func (t *Transport) SetWeightedEndpoints(endpoints []string, weights map[string]int) {
+	t.setEndpoints(endpoints, weights)
 	t.triggerEvent()
 }
 
-func (t *Transport) setEndpoints(endpoints []string) {
+func (t *Transport) setEndpoints(endpoints []string, weights map[string]int) {
 	// copy the contents,
 	// just to be triple sure an external client won't mess with stuff.
 	eps := make([]string, len(endpoints), len(endpoints))
 	copy(eps, endpoints)
 
+	weighted := newSmoothWeighted(eps, weights)
+
+	t.lock.Lock()
+	t.weighted = weighted
 	t.endpoints = eps
+	t.lock.Unlock()
+}
+
+// SetLocality makes the transport prefer the endpoints of the watch in the same zone,
+// spilling over to the others if there are not enough, see serversets.Locality.
+// The watch must have the members, like serversets.Watch. Nil uses all the endpoints again.
+// Endpoints set with SetEndpoints are not filtered.
+func (t *Transport) SetLocality(locality *serversets.Locality) {
+	var l *serversets.Locality
+	if locality != nil {
+		c := *locality
+		l = &c
+	}
+
+	t.lock.Lock()
+	t.locality = l
+	t.lock.Unlock()
+
+	if t.Watcher != nil {
+		t.SetWeightedEndpoints(t.watchEndpoints(t.Watcher))
+	}
+}
+
+// watchEndpoints returns the endpoints of the watch to balance over,
+// only the local ones if there is a locality, and their weights.
+func (t *Transport) watchEndpoints(watch Watcher) ([]string, map[string]int) {
+	endpoints := watch.Endpoints()
+
+	t.lock.Lock()
+	locality := t.locality
+	t.lock.Unlock()
+
+	if locality != nil {
+		if members := serversets.WatcherMembers(watch); members != nil {
+			endpoints = locality.Select(endpoints, members)
+		}
+	}
+
+	return endpoints, serversets.WatcherWeights(watch)
 }
 
-// RotateEndpoint returns host:port for the endpoints in a round-robin fashion.
+// RotateEndpoint returns host:port for the endpoints in a round-robin fashion,
+// each endpoint is returned in proportion to its weight.
 func (t *Transport) RotateEndpoint() (string, error) {
-	if len(t.endpoints) == 0 {
+	t.lock.Lock()
+	eps, weighted := t.endpoints, t.weighted
+	t.lock.Unlock()
+
+	if len(eps) == 0 {
 		return "", ErrNoServers
 	}
 
+	if weighted != nil {
+		return weighted.next(), nil
+	}
+
 	c := atomic.AddInt64(&t.count, 1)
-	eps := t.endpoints
 	return eps[c%int64(len(eps))], nil
 }
 
+// smoothWeighted picks the endpoints in smooth weighted round-robin order, the way
+// nginx does it. Heavier endpoints are picked more often, spread out instead of in a row.
+// Only the current weights are kept, so memory doesn't depend on the weights.
+type smoothWeighted struct {
+	lock      sync.Mutex
+	endpoints []string
+	weights   []int
+	current   []int
+	total     int
+}
+
+// newSmoothWeighted returns nil if all the endpoints have the same weight,
+// so plain round-robin can be used.
+func newSmoothWeighted(endpoints []string, weights map[string]int) *smoothWeighted {
+	ws := make([]int, len(endpoints))
+	total, equal := 0, true
+	for i, e := range endpoints {
+		ws[i] = weights[e]
+		if ws[i] < 1 {
+			ws[i] = 1
+		}
+
+		total += ws[i]
+		equal = equal && ws[i] == ws[0]
+	}
+
+	if equal {
+		return nil
+	}
+
+	return &smoothWeighted{
+		endpoints: endpoints,
+		weights:   ws,
+		current:   make([]int, len(endpoints)),
+		total:     total,
+	}
+}
+
+// next returns the next endpoint, the one furthest behind its share.
+func (s *smoothWeighted) next() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	best := 0
+	for i, w := range s.weights {
+		s.current[i] += w
+		if s.current[i] > s.current[best] {
+			best = i
+		}
+	}
+
+	s.current[best] -= s.total
+	return s.endpoints[best]
+}
+
 // triggerEvent, will queue up something in the Event channel if there isn't already something there.
 func (t *Transport) triggerEvent() {
 	t.EventCount++
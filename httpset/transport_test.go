@@ -1,11 +1,14 @@
 package httpset
 
 import (
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 
+	"github.com/strava/go.serversets"
 	"github.com/strava/go.serversets/fixedset"
 )
 
@@ -106,6 +109,111 @@ func TestTransportRotateServer(t *testing.T) {
 	}
 }
 
+func TestTransportRotateWeighted(t *testing.T) {
+	transport := NewTransport(nil)
+	transport.SetWeightedEndpoints(
+		[]string{"localhost:2181", "localhost:2182"},
+		map[string]int{"localhost:2181": 2})
+
+	counts := make(map[string]int)
+	for i := 0; i < 6; i++ {
+		ep, _ := transport.RotateEndpoint()
+		counts[ep]++
+	}
+
+	if counts["localhost:2181"] != 4 || counts["localhost:2182"] != 2 {
+		t.Errorf("should rotate by weight, got %v", counts)
+	}
+
+	if l := len(transport.Endpoints()); l != 2 {
+		t.Errorf("should have two endpoints, got %v", transport.Endpoints())
+	}
+}
+
+func TestSmoothWeighted(t *testing.T) {
+	w := newSmoothWeighted([]string{"a", "b", "c"}, map[string]int{"a": 10, "b": 5, "c": 5})
+
+	var picks []string
+	for i := 0; i < 4; i++ {
+		picks = append(picks, w.next())
+	}
+
+	if s := strings.Join(picks, ","); s != "a,b,c,a" {
+		t.Errorf("should spread out the heavier endpoint, got %v", s)
+	}
+
+	if w := newSmoothWeighted([]string{"a", "b"}, map[string]int{"a": 2, "b": 2}); w != nil {
+		t.Errorf("should use plain round-robin with equal weights")
+	}
+
+	// memory doesn't depend on the weights
+	w = newSmoothWeighted([]string{"a", "b"}, map[string]int{"a": math.MaxInt32})
+	if e := w.next(); e != "a" {
+		t.Errorf("should pick the heavier endpoint, got %v", e)
+	}
+}
+
+type zonedWatch struct {
+	*fixedset.FixedSet
+	members []serversets.Member
+}
+
+func (w *zonedWatch) Members() []serversets.Member {
+	return w.members
+}
+
+func TestTransportLocality(t *testing.T) {
+	watch := &zonedWatch{
+		FixedSet: fixedset.New([]string{"localhost:2181", "localhost:2182"}),
+		members: []serversets.Member{
+			{ServiceEndpoint: serversets.MemberEndpoint{Host: "localhost", Port: 2181}, Status: serversets.StatusAlive, Zone: "a"},
+			{ServiceEndpoint: serversets.MemberEndpoint{Host: "localhost", Port: 2182}, Status: serversets.StatusAlive, Zone: "b"},
+		},
+	}
+
+	transport := NewTransport(watch)
+	transport.SetLocality(&serversets.Locality{Zone: "a"})
+
+	for i := 0; i < 3; i++ {
+		if ep, _ := transport.RotateEndpoint(); ep != "localhost:2181" {
+			t.Errorf("should only use the local zone, got %v", ep)
+		}
+	}
+
+	transport.SetLocality(nil)
+	if l := len(transport.Endpoints()); l != 2 {
+		t.Errorf("should use all the endpoints again, got %v", transport.Endpoints())
+	}
+}
+
+func TestTransportLocalityConcurrent(t *testing.T) {
+	watch := &zonedWatch{
+		FixedSet: fixedset.New([]string{"localhost:2181", "localhost:2182"}),
+		members: []serversets.Member{
+			{ServiceEndpoint: serversets.MemberEndpoint{Host: "localhost", Port: 2181}, Status: serversets.StatusAlive, Zone: "a"},
+			{ServiceEndpoint: serversets.MemberEndpoint{Host: "localhost", Port: 2182}, Status: serversets.StatusAlive, Zone: "b"},
+		},
+	}
+
+	transport := NewTransport(watch)
+
+	// the watch goroutine updates the endpoints meanwhile
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			watch.SetEndpoints([]string{"localhost:2181", "localhost:2182"})
+			transport.RotateEndpoint()
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		transport.SetLocality(&serversets.Locality{Zone: "a"})
+		transport.RotateEndpoint()
+	}
+	<-done
+}
+
 func TestTransportTriggerEvent(t *testing.T) {
 	transport := NewTransport(nil)
 
@@ -198,3 +306,36 @@ func TestTransport(t *testing.T) {
 		t.Errorf("should hit the first server, got %v", count1)
 	}
 }
+
+func TestTransportSubscribes(t *testing.T) {
+	closed := make(chan struct{}, 1)
+	watcherClosed = func() {
+		closed <- struct{}{}
+	}
+
+	set := serversets.NewWithDialer(serversets.Test, "gotest", serversets.NewMemoryStore().Dial)
+	watch, err := set.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport := NewTransport(watch)
+
+	ep, err := set.RegisterEndpoint("localhost", 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Close()
+
+	// both this reader and the transport should get the event
+	<-watch.Event()
+	<-transport.Event()
+
+	if eps := transport.Endpoints(); len(eps) != 1 {
+		t.Errorf("should have one endpoint, got %v", eps)
+	}
+
+	// wait for the transport goroutine to quit
+	watch.Close()
+	<-closed
+}
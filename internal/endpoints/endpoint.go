@@ -12,6 +12,9 @@ type endpoint struct {
 	host   string
 	active int
 
+	// weight is the relative share of connections, guarded by the lock of the set.
+	weight int
+
 	lock sync.RWMutex
 	cond *sync.Cond
 
@@ -24,6 +27,7 @@ func newEndpoint(pooler Pooler, host string) *endpoint {
 	return &endpoint{
 		Pooler: pooler,
 		host:   host,
+		weight: 1,
 		idle:   list.New(),
 		done:   make(chan struct{}),
 	}
@@ -60,7 +60,7 @@ func (s *Set) Close() error {
 }
 
 // GetConn returns a connection from the endpoint with the current
-// least amount of active connections.
+// least amount of active connections, relative to its weight.
 func (s *Set) GetConn() (*Conn, error) {
 	s.lock.RLock()
 	if len(s.list) == 0 {
@@ -68,8 +68,9 @@ func (s *Set) GetConn() (*Conn, error) {
 		return nil, ErrNoEndpoints
 	}
 
-	// math.MaxInt32, just greater than the practical maximum for active connections
-	min := 1<<31 - 1
+	// the load once the connection is added, active+1 / weight,
+	// so heavier endpoints are preferred when idle.
+	minActive, minWeight := 0, 0
 	var minEP *endpoint
 
 	// TODO: would be interesting to implement a min-heap here.
@@ -78,8 +79,9 @@ func (s *Set) GetConn() (*Conn, error) {
 			continue
 		}
 
-		if a := ep.ActiveConnections(); a < min {
-			min = a
+		a := ep.ActiveConnections() + 1
+		if minEP == nil || a*minWeight < minActive*ep.weight {
+			minActive, minWeight = a, ep.weight
 			minEP = ep
 		}
 	}
@@ -101,6 +103,13 @@ func (s *Set) GetConn() (*Conn, error) {
 // SetEndpoints will do a smart update of the endpoint lists. New hosts
 // will be added, old ones will be removed.
 func (s *Set) SetEndpoints(hosts []string) (added, removed int) {
+	return s.SetWeightedEndpoints(hosts, nil)
+}
+
+// SetWeightedEndpoints updates the endpoint list, same as SetEndpoints,
+// and sets the weight of each host. Hosts missing from the weights,
+// or with a weight less than 1, have a weight of 1.
+func (s *Set) SetWeightedEndpoints(hosts []string, weights map[string]int) (added, removed int) {
 	shuffleHosts(hosts)
 	s.lock.Lock()
 
@@ -141,6 +150,13 @@ func (s *Set) SetEndpoints(hosts []string) (added, removed int) {
 		}
 	}
 
+	for _, ep := range s.list {
+		ep.weight = 1
+		if w := weights[ep.Host()]; w > 1 {
+			ep.weight = w
+		}
+	}
+
 	s.lock.Unlock()
 
 	for _, e := range toRemove {
@@ -59,6 +59,33 @@ func TestSetGetConn(t *testing.T) {
 	}
 }
 
+func TestSetGetConnWeighted(t *testing.T) {
+	tp := &testPooler{}
+	set := NewSet(tp)
+	set.SetWeightedEndpoints([]string{"host1", "host2"}, map[string]int{"host1": 3})
+
+	counts := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		c, err := set.GetConn()
+		if err != nil {
+			t.Fatalf("should get connection, got %v", err)
+		}
+		counts[c.Endpoint]++
+	}
+
+	if counts["host1"] != 6 || counts["host2"] != 2 {
+		t.Errorf("should balance active connections by weight, got %v", counts)
+	}
+
+	// back to equal weights
+	set.SetEndpoints([]string{"host1", "host2"})
+	for _, ep := range set.list {
+		if ep.weight != 1 {
+			t.Errorf("should reset weight, got %v", ep.weight)
+		}
+	}
+}
+
 func TestSetGetConnClosedEndpoint(t *testing.T) {
 	tp := &testPooler{}
 	set := NewSet(tp)
@@ -0,0 +1,73 @@
+package serversets
+
+// A Locality prefers the endpoints in the same zone as the consumer, to avoid
+// paying for cross-zone traffic. If there are not enough healthy endpoints in the
+// zone it spills over to the region, then to all the endpoints.
+// See httpset.Transport.SetLocality and thriftset.ThriftSet.SetLocality.
+type Locality struct {
+	// Zone and Region of the consumer, matched against the ones advertised by
+	// the members. Empty to not prefer any.
+	Zone   string
+	Region string
+
+	// MinHealthy is the fraction, from 0 to 1, of the members of the zone that must be
+	// alive to only use the zone. Members that are not alive, e.g. failing their ping,
+	// count against it. Zero only spills over when no endpoint in the zone is alive.
+	// Same for the region.
+	MinHealthy float64
+}
+
+// Select returns the endpoints to use, given the current endpoints and members of a watch.
+// Endpoints without an alive member, e.g. set manually, are only used when spilling over.
+func (l *Locality) Select(endpoints []string, members []Member) []string {
+	if l.Zone == "" && l.Region == "" {
+		return endpoints
+	}
+
+	alive := make(map[string]Member, len(members))
+	zoneTotal, regionTotal := 0, 0
+	for _, m := range members {
+		if m.Status == StatusAlive {
+			alive[m.ServiceEndpoint.String()] = m
+		}
+
+		if l.Zone != "" && m.Zone == l.Zone {
+			zoneTotal++
+		}
+
+		if l.Region != "" && m.Region == l.Region {
+			regionTotal++
+		}
+	}
+
+	var zone, region []string
+	for _, e := range endpoints {
+		m, ok := alive[e]
+		if !ok {
+			continue
+		}
+
+		if l.Zone != "" && m.Zone == l.Zone {
+			zone = append(zone, e)
+		}
+
+		if l.Region != "" && m.Region == l.Region {
+			region = append(region, e)
+		}
+	}
+
+	if l.healthy(len(zone), zoneTotal) {
+		return zone
+	}
+
+	if l.healthy(len(region), regionTotal) {
+		return region
+	}
+
+	return endpoints
+}
+
+// healthy returns if enough of the members are alive to not spill over.
+func (l *Locality) healthy(alive, total int) bool {
+	return alive > 0 && float64(alive) >= l.MinHealthy*float64(total)
+}
@@ -0,0 +1,75 @@
+package serversets
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLocalitySelect(t *testing.T) {
+	member := func(port int, zone, status string) Member {
+		return Member{
+			ServiceEndpoint: MemberEndpoint{"localhost", port},
+			Status:          status,
+			Zone:            zone,
+			Region:          "us-east-1",
+		}
+	}
+
+	members := []Member{
+		member(1, "us-east-1a", StatusAlive),
+		member(2, "us-east-1a", StatusWarning),
+		member(3, "us-east-1b", StatusAlive),
+		member(4, "us-east-1b", StatusAlive),
+	}
+	endpoints := aliveEndpoints(members)
+
+	l := &Locality{Zone: "us-east-1a"}
+	if eps := l.Select(endpoints, members); !reflect.DeepEqual(eps, []string{"localhost:1"}) {
+		t.Errorf("should prefer the zone, got %v", eps)
+	}
+
+	l.MinHealthy = 0.75
+	if eps := l.Select(endpoints, members); !reflect.DeepEqual(eps, endpoints) {
+		t.Errorf("should spill over when too few are healthy, got %v", eps)
+	}
+
+	l = &Locality{Zone: "us-east-1c"}
+	if eps := l.Select(endpoints, members); !reflect.DeepEqual(eps, endpoints) {
+		t.Errorf("should spill over when the zone is empty, got %v", eps)
+	}
+
+	l = &Locality{Zone: "us-west-2a", Region: "us-east-1"}
+	if eps := l.Select(endpoints, members); !reflect.DeepEqual(eps, endpoints) {
+		t.Errorf("should spill over to the region, got %v", eps)
+	}
+
+	l = &Locality{}
+	if eps := l.Select(endpoints, members); !reflect.DeepEqual(eps, endpoints) {
+		t.Errorf("should use all the endpoints without a zone, got %v", eps)
+	}
+}
+
+func TestLocalityAdvertised(t *testing.T) {
+	set := newTestSet()
+
+	watch, err := set.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch.Close()
+
+	ep, err := set.RegisterEndpointWithOptions("localhost", 1, nil, EndpointOptions{
+		Zone:   "us-east-1a",
+		Region: "us-east-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Close()
+	<-watch.Event()
+
+	members := watch.Members()
+	if len(members) != 1 || members[0].Zone != "us-east-1a" || members[0].Region != "us-east-1" {
+		t.Errorf("should advertise zone and region, got %v", members)
+	}
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/golang/groupcache/consistenthash"
 	"github.com/reusee/mmh3"
+	"github.com/strava/go.serversets"
 )
 
 var (
@@ -66,11 +67,12 @@ func New(watch Watcher) *MCSet {
 	if watch != nil {
 		// first time don't trigger an event
 		mcset.setEndpoints(watch.Endpoints())
+		events, stop := serversets.WatcherEvents(watch)
 
 		go func() {
 			for {
 				select {
-				case <-watch.Event():
+				case <-events:
 					mcset.SetEndpoints(watch.Endpoints())
 				}
 
@@ -79,6 +81,7 @@ func New(watch Watcher) *MCSet {
 				}
 			}
 
+			stop()
 			watcherClosed()
 		}()
 	}
@@ -0,0 +1,151 @@
+package serversets
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Possible member statuses, same as Finagle. Only ALIVE members are
+// included in the Watch endpoint list.
+const (
+	StatusDead     = "DEAD"
+	StatusStarting = "STARTING"
+	StatusAlive    = "ALIVE"
+	StatusStopping = "STOPPING"
+	StatusStopped  = "STOPPED"
+	StatusWarning  = "WARNING"
+	StatusUnknown  = "UNKNOWN"
+)
+
+// A Member is a single member of the server set as stored in its znode.
+type Member struct {
+	// Name is the name of the znode, e.g. member_0000000318
+	Name string
+
+	// Sequence is the sequence number Zookeeper added to the name, -1 if the name doesn't have one.
+	Sequence int64
+
+	ServiceEndpoint     MemberEndpoint
+	AdditionalEndpoints map[string]MemberEndpoint
+	Status              string
+	Shard               *int // nil if not set
+	Metadata            map[string]string
+
+	// Weight is the relative share of the traffic the member should get,
+	// 0 if not advertised, which counts as 1.
+	Weight int
+
+	// Zone and Region are where the member runs, empty if not advertised.
+	Zone   string
+	Region string
+
+	Created  time.Time
+	Modified time.Time
+
+	// Cluster is the name of the cluster the member was found in, only set by a MultiWatch.
+	Cluster string
+}
+
+// A MemberEndpoint is a host and port advertised by a member.
+type MemberEndpoint struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+// String returns the endpoint as host:port.
+func (e MemberEndpoint) String() string {
+	return net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+}
+
+// structure of the data in each member znode
+// Mimics finagle serverset structure.
+type entity struct {
+	ServiceEndpoint     MemberEndpoint            `json:"serviceEndpoint"`
+	AdditionalEndpoints map[string]MemberEndpoint `json:"additionalEndpoints"`
+	Status              string                    `json:"status"`
+	Shard               *int                      `json:"shard,omitempty"`
+	Metadata            map[string]string         `json:"metadata,omitempty"`
+	Weight              int                       `json:"weight,omitempty"`
+	Zone                string                    `json:"zone,omitempty"`
+	Region              string                    `json:"region,omitempty"`
+}
+
+func newEntity(host string, port int, options EndpointOptions) *entity {
+	e := &entity{
+		ServiceEndpoint:     MemberEndpoint{host, port},
+		AdditionalEndpoints: options.AdditionalEndpoints,
+		Status:              StatusAlive,
+		Shard:               options.Shard,
+		Metadata:            options.Metadata,
+		Weight:              options.Weight,
+		Zone:                options.Zone,
+		Region:              options.Region,
+	}
+
+	if e.AdditionalEndpoints == nil {
+		// finagle expects the map to be present
+		e.AdditionalEndpoints = make(map[string]MemberEndpoint)
+	}
+
+	return e
+}
+
+// newMember creates a member from the znode name, data and stat.
+func newMember(name string, e *entity, stat *Stat) *Member {
+	m := &Member{
+		Name:                name,
+		Sequence:            memberSequence(name),
+		ServiceEndpoint:     e.ServiceEndpoint,
+		AdditionalEndpoints: e.AdditionalEndpoints,
+		Status:              e.Status,
+		Shard:               e.Shard,
+		Metadata:            e.Metadata,
+		Weight:              e.Weight,
+		Zone:                e.Zone,
+		Region:              e.Region,
+	}
+
+	if m.AdditionalEndpoints == nil {
+		m.AdditionalEndpoints = make(map[string]MemberEndpoint)
+	}
+
+	if m.Metadata == nil {
+		m.Metadata = make(map[string]string)
+	}
+
+	if stat != nil {
+		m.Created = stat.Created
+		m.Modified = stat.Modified
+	}
+
+	return m
+}
+
+// bySequence sorts members by sequence number, then name.
+type bySequence []Member
+
+func (s bySequence) Len() int      { return len(s) }
+func (s bySequence) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s bySequence) Less(i, j int) bool {
+	if s[i].Sequence != s[j].Sequence {
+		return s[i].Sequence < s[j].Sequence
+	}
+
+	return s[i].Name < s[j].Name
+}
+
+// memberSequence parses the sequence number from the end of the znode name.
+func memberSequence(name string) int64 {
+	i := strings.LastIndexFunc(name, func(r rune) bool {
+		return r < '0' || r > '9'
+	})
+
+	seq, err := strconv.ParseInt(name[i+1:], 10, 64)
+	if err != nil {
+		return -1
+	}
+
+	return seq
+}
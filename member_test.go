@@ -0,0 +1,89 @@
+package serversets
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMemberSequence(t *testing.T) {
+	cases := map[string]int64{
+		"member_0000000318": 318,
+		"member_0000000000": 0,
+		"member_":           -1,
+		"random":            -1,
+		"0000000007":        7,
+	}
+
+	for name, expected := range cases {
+		if seq := memberSequence(name); seq != expected {
+			t.Errorf("incorrect sequence for %s, got %d", name, seq)
+		}
+	}
+}
+
+func TestNewMember(t *testing.T) {
+	// as written by Finagle
+	data := `{"serviceEndpoint":{"host":"10.0.0.1","port":8080},` +
+		`"additionalEndpoints":{"admin":{"host":"10.0.0.1","port":9990}},` +
+		`"status":"ALIVE","shard":3}`
+
+	e := &entity{}
+	if err := json.Unmarshal([]byte(data), e); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newMember("member_0000000318", e, nil)
+	if m.Sequence != 318 {
+		t.Errorf("incorrect sequence, got %d", m.Sequence)
+	}
+
+	if v := m.ServiceEndpoint.String(); v != "10.0.0.1:8080" {
+		t.Errorf("incorrect service endpoint, got %v", v)
+	}
+
+	if v := m.AdditionalEndpoints["admin"].String(); v != "10.0.0.1:9990" {
+		t.Errorf("incorrect admin endpoint, got %v", v)
+	}
+
+	if m.Shard == nil || *m.Shard != 3 {
+		t.Errorf("incorrect shard, got %v", m.Shard)
+	}
+
+	// missing additional endpoints and shard
+	e = &entity{}
+	json.Unmarshal([]byte(`{"serviceEndpoint":{"host":"10.0.0.1","port":8080},"status":"ALIVE"}`), e)
+
+	m = newMember("member_0000000001", e, nil)
+	if m.AdditionalEndpoints == nil {
+		t.Errorf("additional endpoints should not be nil")
+	}
+
+	if m.Shard != nil {
+		t.Errorf("shard should not be set, got %v", *m.Shard)
+	}
+}
+
+func TestNewEntityNoShard(t *testing.T) {
+	data, _ := json.Marshal(newEntity("localhost", 1, EndpointOptions{}))
+
+	expected := `{"serviceEndpoint":{"host":"localhost","port":1},"additionalEndpoints":{},"status":"ALIVE"}`
+	if string(data) != expected {
+		t.Errorf("incorrect entity data, got %s", data)
+	}
+}
+
+func TestNewEntityOptions(t *testing.T) {
+	shard := 3
+	data, _ := json.Marshal(newEntity("localhost", 1, EndpointOptions{
+		AdditionalEndpoints: map[string]MemberEndpoint{"admin": {"localhost", 9990}},
+		Shard:               &shard,
+		Metadata:            map[string]string{"version": "1.2"},
+	}))
+
+	expected := `{"serviceEndpoint":{"host":"localhost","port":1},` +
+		`"additionalEndpoints":{"admin":{"host":"localhost","port":9990}},` +
+		`"status":"ALIVE","shard":3,"metadata":{"version":"1.2"}}`
+	if string(data) != expected {
+		t.Errorf("incorrect entity data, got %s", data)
+	}
+}
@@ -0,0 +1,358 @@
+package serversets
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A MemoryStore is an in-memory implementation of the discovery store.
+// Every session dialed from the store sees the same tree of nodes, so endpoints
+// and watches in the same process can find each other without Zookeeper.
+// Mostly useful for testing.
+type MemoryStore struct {
+	lock     sync.Mutex
+	nodes    map[string]*memoryNode
+	sessions map[*memorySession]struct{}
+}
+
+type memoryNode struct {
+	data     []byte
+	acl      []ACL
+	stat     Stat
+	owner    *memorySession // nil for persistent nodes
+	sequence int            // next sequence number for children
+	children map[string]struct{}
+	watches  []memoryWatch
+}
+
+type memoryWatch struct {
+	session *memorySession
+	changed chan struct{}
+}
+
+// NewMemoryStore creates a new, empty, in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		nodes: map[string]*memoryNode{
+			"/": newMemoryNode(nil, nil, nil),
+		},
+		sessions: make(map[*memorySession]struct{}),
+	}
+}
+
+func newMemoryNode(data []byte, acl []ACL, owner *memorySession) *memoryNode {
+	now := time.Now()
+	return &memoryNode{
+		data:     data,
+		acl:      acl,
+		stat:     Stat{Created: now, Modified: now},
+		owner:    owner,
+		children: make(map[string]struct{}),
+	}
+}
+
+// Dial creates a new session with the store. It implements the Dialer type
+// so it can be passed to NewWithDialer.
+func (s *MemoryStore) Dial() (Backend, error) {
+	session := &memorySession{
+		store:  s,
+		events: make(chan SessionEvent, 6),
+		done:   make(chan struct{}),
+	}
+
+	s.lock.Lock()
+	s.sessions[session] = struct{}{}
+	s.lock.Unlock()
+
+	session.events <- SessionEvent{State: SessionConnected}
+	return session, nil
+}
+
+// ExpireSessions expires every open session, as if the Zookeeper ensemble had lost
+// contact with the clients. Ephemeral members are removed and watches are invalidated.
+// Sessions dialed afterwards are unaffected.
+func (s *MemoryStore) ExpireSessions() {
+	s.lock.Lock()
+	sessions := make([]*memorySession, 0, len(s.sessions))
+	for session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	s.lock.Unlock()
+
+	for _, session := range sessions {
+		// Zookeeper sends the expired event before invalidating the watches.
+		select {
+		case session.events <- SessionEvent{State: SessionExpired}:
+		default:
+		}
+
+		s.closeSession(session)
+	}
+}
+
+// create adds a node to the tree. The lock must be held.
+func (s *MemoryStore) create(p string, data []byte, acl []ACL, owner *memorySession) error {
+	if _, ok := s.nodes[p]; ok {
+		return fmt.Errorf("serversets: node %s already exists", p)
+	}
+
+	dir, name := path.Split(p)
+	parent, ok := s.nodes[path.Clean(dir)]
+	if !ok {
+		return ErrNoNode
+	}
+
+	s.nodes[p] = newMemoryNode(data, acl, owner)
+	parent.children[name] = struct{}{}
+	parent.trigger()
+
+	return nil
+}
+
+// remove deletes a node from the tree. The lock must be held.
+func (s *MemoryStore) remove(p string) error {
+	node, ok := s.nodes[p]
+	if !ok {
+		return ErrNoNode
+	}
+
+	if len(node.children) != 0 {
+		return fmt.Errorf("serversets: node %s has children", p)
+	}
+
+	dir, name := path.Split(p)
+	parent := s.nodes[path.Clean(dir)]
+
+	delete(s.nodes, p)
+	delete(parent.children, name)
+	parent.trigger()
+	node.trigger()
+
+	return nil
+}
+
+// closeSession removes the session and all the ephemeral nodes it owns.
+func (s *MemoryStore) closeSession(session *memorySession) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.sessions[session]; !ok {
+		return
+	}
+
+	delete(s.sessions, session)
+	close(session.done)
+
+	for p, node := range s.nodes {
+		if node.owner == session {
+			s.remove(p)
+		}
+	}
+
+	// like Zookeeper, watches are invalidated when the session ends
+	for _, node := range s.nodes {
+		watches := node.watches[:0]
+		for _, w := range node.watches {
+			if w.session == session {
+				close(w.changed)
+			} else {
+				watches = append(watches, w)
+			}
+		}
+		node.watches = watches
+	}
+}
+
+// trigger fires and clears all the watches on the node. The lock must be held.
+func (n *memoryNode) trigger() {
+	for _, w := range n.watches {
+		close(w.changed)
+	}
+
+	n.watches = nil
+}
+
+// memorySession implements the Backend interface for a MemoryStore.
+type memorySession struct {
+	store  *MemoryStore
+	events chan SessionEvent
+	done   chan struct{}
+}
+
+func (ms *memorySession) closed() bool {
+	select {
+	case <-ms.done:
+		return true
+	default:
+	}
+
+	return false
+}
+
+// CreatePath creates the directories, the ACL is stored but not enforced.
+func (ms *memorySession) CreatePath(p string, acl []ACL) error {
+	ms.store.lock.Lock()
+	defer ms.store.lock.Unlock()
+
+	if ms.closed() {
+		return ErrSessionClosed
+	}
+
+	for _, key := range splitPaths(p) {
+		if _, ok := ms.store.nodes[key]; ok {
+			continue
+		}
+
+		if err := ms.store.create(key, nil, acl, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CreateMember creates the member, the ACL is stored but not enforced.
+func (ms *memorySession) CreateMember(prefix string, data []byte, acl []ACL) (string, error) {
+	ms.store.lock.Lock()
+	defer ms.store.lock.Unlock()
+
+	if ms.closed() {
+		return "", ErrSessionClosed
+	}
+
+	parent, ok := ms.store.nodes[path.Dir(prefix)]
+	if !ok {
+		return "", ErrNoNode
+	}
+
+	// same format as Zookeeper sequential nodes
+	p := fmt.Sprintf("%s%010d", prefix, parent.sequence)
+	parent.sequence++
+
+	d := make([]byte, len(data))
+	copy(d, data)
+
+	if err := ms.store.create(p, d, acl, ms); err != nil {
+		return "", err
+	}
+
+	return p, nil
+}
+
+func (ms *memorySession) SetMember(p string, data []byte) error {
+	ms.store.lock.Lock()
+	defer ms.store.lock.Unlock()
+
+	if ms.closed() {
+		return ErrSessionClosed
+	}
+
+	node, ok := ms.store.nodes[p]
+	if !ok {
+		return ErrNoNode
+	}
+
+	node.data = make([]byte, len(data))
+	copy(node.data, data)
+	node.stat.Modified = time.Now()
+	node.trigger()
+
+	return nil
+}
+
+func (ms *memorySession) DeleteMember(p string) error {
+	ms.store.lock.Lock()
+	defer ms.store.lock.Unlock()
+
+	if ms.closed() {
+		return ErrSessionClosed
+	}
+
+	return ms.store.remove(p)
+}
+
+func (ms *memorySession) Get(p string) ([]byte, *Stat, error) {
+	data, stat, _, err := ms.get(p, false)
+	return data, stat, err
+}
+
+// GetW watches the node, the memory store doesn't distinguish data and child watches.
+func (ms *memorySession) GetW(p string) ([]byte, *Stat, <-chan struct{}, error) {
+	return ms.get(p, true)
+}
+
+func (ms *memorySession) get(p string, watch bool) ([]byte, *Stat, <-chan struct{}, error) {
+	ms.store.lock.Lock()
+	defer ms.store.lock.Unlock()
+
+	if ms.closed() {
+		return nil, nil, nil, ErrSessionClosed
+	}
+
+	node, ok := ms.store.nodes[p]
+	if !ok {
+		return nil, nil, nil, ErrNoNode
+	}
+
+	data := make([]byte, len(node.data))
+	copy(data, node.data)
+	stat := node.stat
+
+	if !watch {
+		return data, &stat, nil, nil
+	}
+
+	w := memoryWatch{session: ms, changed: make(chan struct{})}
+	node.watches = append(node.watches, w)
+
+	return data, &stat, w.changed, nil
+}
+
+func (ms *memorySession) Children(p string) ([]string, error) {
+	children, _, err := ms.children(p, false)
+	return children, err
+}
+
+func (ms *memorySession) ChildrenW(p string) ([]string, <-chan struct{}, error) {
+	return ms.children(p, true)
+}
+
+func (ms *memorySession) children(p string, watch bool) ([]string, <-chan struct{}, error) {
+	ms.store.lock.Lock()
+	defer ms.store.lock.Unlock()
+
+	if ms.closed() {
+		return nil, nil, ErrSessionClosed
+	}
+
+	node, ok := ms.store.nodes[path.Clean(p)]
+	if !ok {
+		return nil, nil, ErrNoNode
+	}
+
+	children := make([]string, 0, len(node.children))
+	for name := range node.children {
+		children = append(children, name)
+	}
+	sort.Strings(children)
+
+	if !watch {
+		return children, nil, nil
+	}
+
+	w := memoryWatch{session: ms, changed: make(chan struct{})}
+	node.watches = append(node.watches, w)
+
+	return children, w.changed, nil
+}
+
+func (ms *memorySession) SessionEvents() <-chan SessionEvent {
+	return ms.events
+}
+
+func (ms *memorySession) Close() {
+	ms.store.closeSession(ms)
+}
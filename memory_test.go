@@ -0,0 +1,196 @@
+package serversets
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMemoryStoreMembers(t *testing.T) {
+	store := NewMemoryStore()
+
+	b, err := store.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if err := b.CreatePath("/discovery/test/gotest", nil); err != nil {
+		t.Fatalf("should create path, got %v", err)
+	}
+
+	// should be able to create the same path again
+	if err := b.CreatePath("/discovery/test/gotest", nil); err != nil {
+		t.Fatalf("should create path again, got %v", err)
+	}
+
+	children, changed, err := b.ChildrenW("/discovery/test/gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(children) != 0 {
+		t.Errorf("should not have children, got %v", children)
+	}
+
+	key, err := b.CreateMember("/discovery/test/gotest/member_", []byte("data"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key != "/discovery/test/gotest/member_0000000000" {
+		t.Errorf("incorrect key, got %v", key)
+	}
+
+	select {
+	case <-changed:
+	default:
+		t.Errorf("watch should fire when member is created")
+	}
+
+	key, _ = b.CreateMember("/discovery/test/gotest/member_", []byte("data"), nil)
+	if key != "/discovery/test/gotest/member_0000000001" {
+		t.Errorf("incorrect key, got %v", key)
+	}
+
+	children, _, _ = b.ChildrenW("/discovery/test/gotest")
+	if !reflect.DeepEqual(children, []string{"member_0000000000", "member_0000000001"}) {
+		t.Errorf("incorrect children, got %v", children)
+	}
+
+	if c, _ := b.Children("/discovery/test/gotest"); !reflect.DeepEqual(c, children) {
+		t.Errorf("incorrect children without watch, got %v", c)
+	}
+
+	if _, err := b.Children("/discovery/test/missing"); err != ErrNoNode {
+		t.Errorf("should not find missing node, got %v", err)
+	}
+
+	data, stat, err := b.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "data" {
+		t.Errorf("incorrect data, got %s", data)
+	}
+
+	if stat.Created.IsZero() || stat.Modified.IsZero() {
+		t.Errorf("should set created and modified times, got %v", stat)
+	}
+
+	if err := b.DeleteMember(key); err != nil {
+		t.Errorf("should delete member, got %v", err)
+	}
+
+	if _, _, err := b.Get(key); err != ErrNoNode {
+		t.Errorf("should not find deleted member, got %v", err)
+	}
+
+	if err := b.DeleteMember(key); err != ErrNoNode {
+		t.Errorf("should not delete member twice, got %v", err)
+	}
+}
+
+func TestMemoryStoreSessionClose(t *testing.T) {
+	store := NewMemoryStore()
+
+	b1, _ := store.Dial()
+	defer b1.Close()
+
+	b2, _ := store.Dial()
+
+	b2.CreatePath("/discovery/test/gotest", nil)
+	b2.CreateMember("/discovery/test/gotest/member_", nil, nil)
+
+	children, changed, _ := b1.ChildrenW("/discovery/test/gotest")
+	if len(children) != 1 {
+		t.Fatalf("should have one member, got %v", children)
+	}
+
+	// ephemeral members go away with the session
+	b2.Close()
+	b2.Close()
+
+	<-changed
+	children, _, _ = b1.ChildrenW("/discovery/test/gotest")
+	if len(children) != 0 {
+		t.Errorf("should not have members, got %v", children)
+	}
+
+	if _, err := b2.CreateMember("/discovery/test/gotest/member_", nil, nil); err != ErrSessionClosed {
+		t.Errorf("should not create on closed session, got %v", err)
+	}
+}
+
+func TestServerSetWithDialer(t *testing.T) {
+	set := NewWithDialer(Test, "gotest", NewMemoryStore().Dial)
+	watch, err := set.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch.Close()
+
+	ep, err := set.RegisterEndpoint("localhost", 1, nil)
+	if err != nil {
+		t.Fatalf("registration failure: %v", err)
+	}
+
+	<-watch.Event()
+	if !reflect.DeepEqual(watch.Endpoints(), []string{"localhost:1"}) {
+		t.Errorf("server list incorrect, got %v", watch.Endpoints())
+	}
+
+	ep.Close()
+
+	<-watch.Event()
+	if !reflect.DeepEqual(watch.Endpoints(), []string{}) {
+		t.Errorf("server list incorrect, got %v", watch.Endpoints())
+	}
+}
+
+func TestMemoryStoreSetMember(t *testing.T) {
+	store := NewMemoryStore()
+
+	b, _ := store.Dial()
+	defer b.Close()
+
+	b.CreatePath("/discovery/test/gotest", nil)
+	key, _ := b.CreateMember("/discovery/test/gotest/member_", []byte("data"), nil)
+
+	data, _, changed, err := b.GetW(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "data" {
+		t.Errorf("incorrect data, got %s", data)
+	}
+
+	if err := b.SetMember(key, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-changed:
+	default:
+		t.Errorf("watch should fire when data is set")
+	}
+
+	data, _, _ = b.Get(key)
+	if string(data) != "new" {
+		t.Errorf("incorrect data, got %s", data)
+	}
+
+	_, _, changed, _ = b.GetW(key)
+	b.DeleteMember(key)
+
+	select {
+	case <-changed:
+	default:
+		t.Errorf("watch should fire when member is deleted")
+	}
+
+	if err := b.SetMember(key, nil); err != ErrNoNode {
+		t.Errorf("should not set deleted member, got %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package serversets
+
+import (
+	"time"
+)
+
+// Names of the metrics reported by endpoints and watches.
+const (
+	MetricRegistrations      = "endpoint.registrations"
+	MetricRegisterTime       = "endpoint.register_time"
+	MetricUnregistrations    = "endpoint.unregistrations"
+	MetricPingHealthy        = "endpoint.ping.healthy"
+	MetricPingUnhealthy      = "endpoint.ping.unhealthy"
+	MetricSessionExpirations = "session.expirations"
+	MetricReconnects         = "session.reconnects"
+	MetricReconnectErrors    = "session.reconnect_errors"
+	MetricWatchEvents        = "watch.events"
+	MetricWatchRefreshTime   = "watch.refresh_time"
+	MetricWatchEndpoints     = "watch.endpoints"
+	MetricWatchMembers       = "watch.members"
+	MetricParseErrors        = "watch.parse_errors"
+	MetricShrinksHeld        = "watch.shrinks_held"
+)
+
+// Metrics receives the counters, gauges and timings of the endpoints and watches
+// of a server set. See NewStatsDMetrics to report them to StatsD.
+// Methods are called from many goroutines and must not block.
+type Metrics interface {
+	Count(name string, value int)
+	Gauge(name string, value int)
+	Timing(name string, d time.Duration)
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) Count(name string, value int)        {}
+func (noopMetrics) Gauge(name string, value int)        {}
+func (noopMetrics) Timing(name string, d time.Duration) {}
+
+// A Stater sends stats to StatsD, same as the go.statsd Stater.
+type Stater interface {
+	CountMultiple(stat string, count int, rate ...float64) error
+	Measure(stat string, delta time.Duration, rate ...float64) error
+	Gauge(stat string, value interface{}) error
+}
+
+type statsdMetrics struct {
+	prefix string
+	stater Stater
+}
+
+// NewStatsDMetrics reports the metrics to StatsD, e.g. a go.statsd client,
+// with the prefix added to the names.
+func NewStatsDMetrics(prefix string, stater Stater) Metrics {
+	return &statsdMetrics{
+		prefix: prefix,
+		stater: stater,
+	}
+}
+
+func (m *statsdMetrics) Count(name string, value int) {
+	m.stater.CountMultiple(m.prefix+name, value)
+}
+
+func (m *statsdMetrics) Gauge(name string, value int) {
+	m.stater.Gauge(m.prefix+name, value)
+}
+
+func (m *statsdMetrics) Timing(name string, d time.Duration) {
+	m.stater.Measure(m.prefix+name, d)
+}
@@ -0,0 +1,203 @@
+package serversets
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type testMetrics struct {
+	lock   sync.Mutex
+	counts map[string]int
+	gauges map[string]int
+	timing map[string]int
+}
+
+func newTestMetrics() *testMetrics {
+	return &testMetrics{
+		counts: make(map[string]int),
+		gauges: make(map[string]int),
+		timing: make(map[string]int),
+	}
+}
+
+func (m *testMetrics) Count(name string, value int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.counts[name] += value
+}
+
+func (m *testMetrics) Gauge(name string, value int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.gauges[name] = value
+}
+
+func (m *testMetrics) Timing(name string, d time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.timing[name]++
+}
+
+func (m *testMetrics) count(name string) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.counts[name]
+}
+
+func TestMetrics(t *testing.T) {
+	metrics := newTestMetrics()
+	set, dialer := newUnreliableSet()
+	set.Metrics = metrics
+
+	watch, err := set.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch.Close()
+
+	ep, err := set.RegisterEndpoint("localhost", 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-watch.Event()
+	if c := metrics.count(MetricRegistrations); c != 1 {
+		t.Errorf("should count registration, got %d", c)
+	}
+
+	if c := metrics.count(MetricWatchEvents); c != 1 {
+		t.Errorf("should count watch event, got %d", c)
+	}
+
+	metrics.lock.Lock()
+	if g := metrics.gauges[MetricWatchEndpoints]; g != 1 {
+		t.Errorf("should gauge endpoints, got %d", g)
+	}
+
+	if metrics.timing[MetricRegisterTime] != 1 || metrics.timing[MetricWatchRefreshTime] != 1 {
+		t.Errorf("should time registration and refresh, got %v", metrics.timing)
+	}
+	metrics.lock.Unlock()
+
+	// invalid member data
+	conn, _ := dialer.Dial()
+	conn.CreateMember(set.directoryPath()+"/"+MemberPrefix, []byte("{"), nil)
+
+	timeout := time.After(time.Second)
+	for metrics.count(MetricParseErrors) == 0 {
+		select {
+		case <-time.After(time.Millisecond):
+		case <-timeout:
+			t.Fatalf("should count parse errors")
+		}
+	}
+
+	conn.Close()
+	<-watch.Event()
+
+	dialer.ExpireSessions()
+	<-watch.Event()
+
+	ep.Close()
+	<-watch.Event()
+
+	if c := metrics.count(MetricSessionExpirations); c != 2 {
+		t.Errorf("should count expirations of endpoint and watch, got %d", c)
+	}
+
+	if c := metrics.count(MetricReconnects); c < 2 {
+		t.Errorf("should count reconnects, got %d", c)
+	}
+}
+
+func TestMetricsPing(t *testing.T) {
+	metrics := newTestMetrics()
+	set := newTestSet()
+	set.Metrics = metrics
+
+	var lock sync.Mutex
+	var pingErr error
+	ping := func() error {
+		lock.Lock()
+		defer lock.Unlock()
+
+		return pingErr
+	}
+
+	ep, err := set.RegisterEndpoint("localhost", 1, ping)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lock.Lock()
+	pingErr = ErrNoNode
+	lock.Unlock()
+
+	// pinged every second
+	timeout := time.After(3 * time.Second)
+	for metrics.count(MetricPingUnhealthy) == 0 {
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-timeout:
+			t.Fatalf("should count ping flip")
+		}
+	}
+	ep.Close()
+
+	if c := metrics.count(MetricPingUnhealthy); c != 1 {
+		t.Errorf("should count ping flip, got %d", c)
+	}
+
+	if c := metrics.count(MetricUnregistrations); c != 1 {
+		t.Errorf("should count unregistering on close, got %d", c)
+	}
+}
+
+type testStater struct {
+	stats []string
+}
+
+func (s *testStater) CountMultiple(stat string, count int, rate ...float64) error {
+	s.stats = append(s.stats, "count "+stat)
+	return nil
+}
+
+func (s *testStater) Measure(stat string, delta time.Duration, rate ...float64) error {
+	s.stats = append(s.stats, "measure "+stat)
+	return nil
+}
+
+func (s *testStater) Gauge(stat string, value interface{}) error {
+	s.stats = append(s.stats, "gauge "+stat)
+	return nil
+}
+
+func TestStatsDMetrics(t *testing.T) {
+	stater := &testStater{}
+	metrics := NewStatsDMetrics("serversets.", stater)
+
+	metrics.Count(MetricRegistrations, 1)
+	metrics.Gauge(MetricWatchEndpoints, 3)
+	metrics.Timing(MetricRegisterTime, time.Second)
+
+	expected := []string{
+		"count serversets.endpoint.registrations",
+		"gauge serversets.watch.endpoints",
+		"measure serversets.endpoint.register_time",
+	}
+
+	if len(stater.stats) != len(expected) {
+		t.Fatalf("incorrect stats, got %v", stater.stats)
+	}
+
+	for i := range expected {
+		if stater.stats[i] != expected[i] {
+			t.Errorf("incorrect stat, got %v", stater.stats[i])
+		}
+	}
+}
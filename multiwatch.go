@@ -0,0 +1,211 @@
+package serversets
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A MultiWatch watches the same server set in several clusters, e.g. one Zookeeper
+// ensemble per datacenter, and merges them into one deduplicated endpoint list.
+// If a cluster is unreachable the others are still served, and its watch is
+// retried in the background. Like Watch, it counts its events in EventCount and LastEvent.
+type MultiWatch struct {
+	aggregator
+
+	// lock for read/writing the watches, endpoints, members and weights
+	lock      sync.RWMutex
+	watches   map[string]*Watch
+	endpoints []string
+	members   []Member
+	weights   map[string]int
+}
+
+// WatchClusters creates a watch on each of the server sets, keyed by cluster name,
+// and merges them. It only returns an error if none of the clusters can be watched.
+func WatchClusters(sets map[string]*ServerSet) (*MultiWatch, error) {
+	mw := &MultiWatch{
+		aggregator: newAggregator(),
+		watches:    make(map[string]*Watch),
+	}
+
+	var lastErr error
+	for name, ss := range sets {
+		watch, err := ss.Watch()
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %v", name, err)
+			reportError(mw.errs, lastErr)
+			continue
+		}
+
+		mw.watches[name] = watch
+	}
+
+	if len(mw.watches) == 0 && len(sets) > 0 {
+		return nil, lastErr
+	}
+
+	// subscribe before merging, so no change is missed
+	watches := make(map[string]*Watch)
+	subs := make(map[string]*Subscription)
+	for name, watch := range mw.watches {
+		watches[name] = watch
+		subs[name] = watch.Subscribe(1)
+	}
+
+	mw.merge()
+
+	for name, ss := range sets {
+		mw.wg.Add(1)
+		go mw.run(name, ss, watches[name], subs[name])
+	}
+
+	return mw, nil
+}
+
+// run watches a single cluster, first retrying to create the watch if needed,
+// and merges its endpoints whenever they change.
+func (mw *MultiWatch) run(name string, ss *ServerSet, watch *Watch, sub *Subscription) {
+	defer mw.wg.Done()
+
+	if watch == nil {
+		backoff := ss.newBackoff()
+		for watch == nil {
+			select {
+			case <-time.After(backoff.next()):
+			case <-mw.done:
+				return
+			}
+
+			var err error
+			watch, err = ss.Watch()
+			if err != nil {
+				reportError(mw.errs, fmt.Errorf("%s: %v", name, err))
+			}
+		}
+
+		sub = watch.Subscribe(1)
+
+		mw.lock.Lock()
+		mw.watches[name] = watch
+		mw.lock.Unlock()
+
+		mw.update(mw.merge)
+	}
+	defer watch.Unsubscribe(sub)
+
+	for {
+		select {
+		case <-sub.Events():
+			mw.update(mw.merge)
+		case err := <-watch.Errors():
+			reportError(mw.errs, fmt.Errorf("%s: %v", name, err))
+		case <-mw.done:
+			return
+		}
+	}
+}
+
+// Endpoints returns the merged, deduplicated and sorted list of endpoints of all the clusters.
+func (mw *MultiWatch) Endpoints() []string {
+	mw.lock.RLock()
+	defer mw.lock.RUnlock()
+
+	return mw.endpoints
+}
+
+// Members returns the members of all the clusters, sorted by cluster name and sequence number.
+// Each member has the Cluster it was found in. Members registered in several clusters
+// are listed once per cluster.
+func (mw *MultiWatch) Members() []Member {
+	mw.lock.RLock()
+	defer mw.lock.RUnlock()
+
+	return mw.members
+}
+
+// Weights returns the weight of each of the merged endpoints. If an endpoint
+// is in several clusters the highest weight is used.
+func (mw *MultiWatch) Weights() map[string]int {
+	mw.lock.RLock()
+	defer mw.lock.RUnlock()
+
+	return mw.weights
+}
+
+// Watch returns the watch of a single cluster, nil if it couldn't be created yet.
+// Use it to check the State of that cluster. It must not be closed directly.
+func (mw *MultiWatch) Watch(cluster string) *Watch {
+	mw.lock.RLock()
+	defer mw.lock.RUnlock()
+
+	return mw.watches[cluster]
+}
+
+// Event returns the event channel. This channel will get an object
+// whenever something changes with the merged list of endpoints.
+func (mw *MultiWatch) Event() <-chan struct{} {
+	return mw.event
+}
+
+// Errors returns a channel that gets the errors of all the clusters,
+// prefixed with the cluster name. Errors are dropped if the channel is not read.
+func (mw *MultiWatch) Errors() <-chan error {
+	return mw.errs
+}
+
+// Close closes the watches of all the clusters.
+func (mw *MultiWatch) Close() {
+	mw.shutdown(func() {
+		for _, watch := range mw.watches {
+			watch.Close()
+		}
+	})
+}
+
+// merge updates the endpoints and members from the watches of all the clusters.
+func (mw *MultiWatch) merge() {
+	mw.lock.Lock()
+	defer mw.lock.Unlock()
+
+	names := make([]string, 0, len(mw.watches))
+	for name := range mw.watches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	seen := make(map[string]struct{})
+	endpoints := make([]string, 0)
+	members := make([]Member, 0)
+	weights := make(map[string]int)
+
+	for _, name := range names {
+		watch := mw.watches[name]
+
+		for _, e := range watch.Endpoints() {
+			if _, ok := seen[e]; ok {
+				continue
+			}
+
+			seen[e] = struct{}{}
+			endpoints = append(endpoints, e)
+		}
+
+		for _, m := range watch.Members() {
+			m.Cluster = name
+			members = append(members, m)
+		}
+
+		for e, weight := range watch.Weights() {
+			if weight > weights[e] {
+				weights[e] = weight
+			}
+		}
+	}
+	sort.Strings(endpoints)
+
+	mw.endpoints = endpoints
+	mw.members = members
+	mw.weights = weights
+}
@@ -0,0 +1,111 @@
+package serversets
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMultiWatch(t *testing.T) {
+	east := NewWithDialer(Test, "gotest", NewMemoryStore().Dial)
+	west := NewWithDialer(Test, "gotest", NewMemoryStore().Dial)
+
+	ep1, _ := east.RegisterEndpoint("localhost", 1, nil)
+	defer ep1.Close()
+
+	ep2, _ := west.RegisterEndpoint("localhost", 2, nil)
+	defer ep2.Close()
+
+	// same endpoint registered in both clusters
+	ep3, _ := east.RegisterEndpoint("localhost", 3, nil)
+	defer ep3.Close()
+
+	ep4, _ := west.RegisterEndpoint("localhost", 3, nil)
+	defer ep4.Close()
+
+	watch, err := WatchClusters(map[string]*ServerSet{"east": east, "west": west})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch.Close()
+
+	expected := []string{"localhost:1", "localhost:2", "localhost:3"}
+	if !reflect.DeepEqual(watch.Endpoints(), expected) {
+		t.Errorf("should merge endpoints, got %v", watch.Endpoints())
+	}
+
+	members := watch.Members()
+	if len(members) != 4 {
+		t.Fatalf("should have all members, got %v", members)
+	}
+
+	clusters := []string{members[0].Cluster, members[1].Cluster, members[2].Cluster, members[3].Cluster}
+	if !reflect.DeepEqual(clusters, []string{"east", "east", "west", "west"}) {
+		t.Errorf("should tag members with cluster, got %v", clusters)
+	}
+
+	// still registered in east
+	ep4.Close()
+	<-watch.Event()
+	if !reflect.DeepEqual(watch.Endpoints(), expected) {
+		t.Errorf("should keep endpoint of other cluster, got %v", watch.Endpoints())
+	}
+
+	ep3.Close()
+	<-watch.Event()
+	if !reflect.DeepEqual(watch.Endpoints(), []string{"localhost:1", "localhost:2"}) {
+		t.Errorf("should remove endpoint, got %v", watch.Endpoints())
+	}
+}
+
+func TestMultiWatchUnreachable(t *testing.T) {
+	east := NewWithDialer(Test, "gotest", NewMemoryStore().Dial)
+	west, dialer := newUnreliableSet()
+	dialer.SetDown(true)
+
+	ep1, _ := east.RegisterEndpoint("localhost", 1, nil)
+	defer ep1.Close()
+
+	watch, err := WatchClusters(map[string]*ServerSet{"east": east, "west": west})
+	if err != nil {
+		t.Fatalf("should serve reachable cluster, got %v", err)
+	}
+	defer watch.Close()
+
+	if !reflect.DeepEqual(watch.Endpoints(), []string{"localhost:1"}) {
+		t.Errorf("incorrect endpoints, got %v", watch.Endpoints())
+	}
+
+	if watch.Watch("west") != nil {
+		t.Errorf("should not have watch for unreachable cluster")
+	}
+
+	if err := <-watch.Errors(); err == nil {
+		t.Errorf("should report error for unreachable cluster")
+	}
+
+	dialer.SetDown(false)
+	ep2, err := west.RegisterEndpoint("localhost", 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep2.Close()
+
+	timeout := time.After(time.Second)
+	for !reflect.DeepEqual(watch.Endpoints(), []string{"localhost:1", "localhost:2"}) {
+		select {
+		case <-watch.Event():
+		case <-timeout:
+			t.Fatalf("should add cluster once reachable, got %v", watch.Endpoints())
+		}
+	}
+}
+
+func TestMultiWatchAllUnreachable(t *testing.T) {
+	set, dialer := newUnreliableSet()
+	dialer.SetDown(true)
+
+	if _, err := WatchClusters(map[string]*ServerSet{"west": set}); err == nil {
+		t.Errorf("should return error if no cluster is reachable")
+	}
+}
@@ -0,0 +1,137 @@
+package serversets
+
+import "sync"
+
+// A Rank is the position of an endpoint among the alive members of its server set,
+// "member Index of Count", e.g. to split a keyspace between workers.
+type Rank struct {
+	// Index is the 0 based position of the member ordered by sequence number,
+	// -1 if the member is not alive or not seen by the watch yet.
+	Index int
+
+	// Count is the number of alive members.
+	Count int
+}
+
+// A RankWatch tracks the Rank of an endpoint in a watch of the same server set.
+type RankWatch struct {
+	endpoint *Endpoint
+	watch    *Watch
+	sub      *Subscription
+	changes  chan Rank
+
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	lock sync.RWMutex
+	rank Rank
+}
+
+// WatchRank tracks the rank of this endpoint among the alive members of the watch,
+// which must be of the same server set. Members keep their rank as long as the members
+// before them stay, so ranks are stable as members come and go.
+func (ep *Endpoint) WatchRank(watch *Watch) *RankWatch {
+	rw := &RankWatch{
+		endpoint: ep,
+		watch:    watch,
+		sub:      watch.Subscribe(1),
+		changes:  make(chan Rank, 1),
+		done:     make(chan struct{}),
+	}
+
+	name, _ := ep.memberName()
+	rw.rank = rankOf(name, watch.Members())
+
+	rw.wg.Add(1)
+	go rw.run()
+
+	return rw
+}
+
+// run updates the rank whenever the members, or the member of the endpoint, change.
+func (rw *RankWatch) run() {
+	defer rw.wg.Done()
+
+	for {
+		name, renamed := rw.endpoint.memberName()
+		rw.setRank(rankOf(name, rw.watch.Members()))
+
+		select {
+		case _, ok := <-rw.sub.Events():
+			if !ok {
+				// the watch was closed
+				return
+			}
+		case <-renamed:
+		case <-rw.endpoint.done:
+			return
+		case <-rw.done:
+			return
+		}
+	}
+}
+
+// Rank returns the current rank of the endpoint.
+func (rw *RankWatch) Rank() Rank {
+	rw.lock.RLock()
+	defer rw.lock.RUnlock()
+
+	return rw.rank
+}
+
+// Changes returns a channel that gets the new rank whenever the index or count change.
+// If not read, only the latest change is kept. It is closed when the rank watch is closed.
+func (rw *RankWatch) Changes() <-chan Rank {
+	return rw.changes
+}
+
+// Close stops tracking the rank. It doesn't close the endpoint or watch.
+func (rw *RankWatch) Close() {
+	select {
+	case <-rw.done:
+		return
+	default:
+	}
+
+	close(rw.done)
+	rw.wg.Wait()
+
+	rw.watch.Unsubscribe(rw.sub)
+	close(rw.changes)
+}
+
+func (rw *RankWatch) setRank(rank Rank) {
+	rw.lock.Lock()
+	changed := rank != rw.rank
+	rw.rank = rank
+	rw.lock.Unlock()
+
+	if !changed {
+		return
+	}
+
+	// only the latest rank matters, replace a pending one
+	select {
+	case <-rw.changes:
+	default:
+	}
+
+	rw.changes <- rank
+}
+
+// rankOf returns the rank of the named member among the alive members, sorted by sequence number.
+func rankOf(name string, members []Member) Rank {
+	rank := Rank{Index: -1}
+	for _, m := range members {
+		if m.Status != StatusAlive {
+			continue
+		}
+
+		if name != "" && m.Name == name {
+			rank.Index = rank.Count
+		}
+		rank.Count++
+	}
+
+	return rank
+}
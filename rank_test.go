@@ -0,0 +1,123 @@
+package serversets
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRankOf(t *testing.T) {
+	members := []Member{
+		{Name: "member_0000000000", Status: StatusAlive},
+		{Name: "member_0000000001", Status: StatusWarning},
+		{Name: "member_0000000002", Status: StatusAlive},
+	}
+
+	if r := rankOf("member_0000000002", members); r != (Rank{Index: 1, Count: 2}) {
+		t.Errorf("should skip members that are not alive, got %v", r)
+	}
+
+	if r := rankOf("member_0000000001", members); r != (Rank{Index: -1, Count: 2}) {
+		t.Errorf("should not rank a member that is not alive, got %v", r)
+	}
+
+	if r := rankOf("", members); r != (Rank{Index: -1, Count: 2}) {
+		t.Errorf("should not rank without a member, got %v", r)
+	}
+}
+
+// waitRank waits for the rank to become the given one.
+func waitRank(t *testing.T, rw *RankWatch, rank Rank) {
+	timeout := time.After(time.Second)
+	for rw.Rank() != rank {
+		select {
+		case <-rw.Changes():
+		case <-timeout:
+			t.Fatalf("rank should be %v, got %v", rank, rw.Rank())
+		}
+	}
+}
+
+func TestEndpointWatchRank(t *testing.T) {
+	set := newTestSet()
+
+	watch, err := set.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch.Close()
+
+	var eps []*Endpoint
+	for port := 1; port <= 3; port++ {
+		ep, err := set.RegisterEndpoint("localhost", port, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ep.Close()
+
+		eps = append(eps, ep)
+	}
+
+	if n := eps[1].MemberName(); n != "member_0000000001" {
+		t.Errorf("incorrect member name, got %v", n)
+	}
+
+	rw := eps[1].WatchRank(watch)
+	defer rw.Close()
+
+	waitRank(t, rw, Rank{Index: 1, Count: 3})
+
+	eps[0].Close()
+	waitRank(t, rw, Rank{Index: 0, Count: 2})
+
+	ep, err := set.RegisterEndpoint("localhost", 4, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Close()
+
+	// members after it don't change the index
+	waitRank(t, rw, Rank{Index: 0, Count: 3})
+
+	rw.Close()
+	if _, ok := <-rw.Changes(); ok {
+		// a pending change may be left
+		if _, ok := <-rw.Changes(); ok {
+			t.Errorf("should close changes")
+		}
+	}
+}
+
+func TestEndpointWatchRankReregister(t *testing.T) {
+	set, dialer := newUnreliableSet()
+
+	watch, err := set.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch.Close()
+
+	ep, err := set.RegisterEndpoint("localhost", 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Close()
+
+	rw := ep.WatchRank(watch)
+	defer rw.Close()
+
+	waitRank(t, rw, Rank{Index: 0, Count: 1})
+
+	name := ep.MemberName()
+	dialer.ExpireSessions()
+
+	timeout := time.After(time.Second)
+	for ep.MemberName() == name || ep.MemberName() == "" {
+		select {
+		case <-time.After(time.Millisecond):
+		case <-timeout:
+			t.Fatalf("should register a new member, got %v", ep.MemberName())
+		}
+	}
+
+	waitRank(t, rw, Rank{Index: 0, Count: 1})
+}
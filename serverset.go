@@ -5,8 +5,6 @@ import (
 	"path"
 	"strings"
 	"time"
-
-	"github.com/samuel/go-zookeeper/zk"
 )
 
 var (
@@ -40,13 +38,75 @@ const (
 // DefaultZKTimeout is the zookeeper timeout used if it is not overwritten.
 var DefaultZKTimeout = 5 * time.Second
 
+// DefaultRetryDelay and DefaultMaxRetryDelay bound the exponential backoff between
+// attempts to reconnect to Zookeeper, or retry failed operations, if they are not overwritten.
+var (
+	DefaultRetryDelay    = 500 * time.Millisecond
+	DefaultMaxRetryDelay = 30 * time.Second
+)
+
+// DefaultDrainGracePeriod is how long Endpoint.Drain waits, after setting the member
+// status to STOPPING, before removing it, if it is not overwritten.
+var DefaultDrainGracePeriod = 5 * time.Second
+
+// DefaultWatchShrinkHold is how long a watch keeps the previous endpoints, after an update
+// that shrinks them more than allowed, before accepting it, if it is not overwritten.
+var DefaultWatchShrinkHold = 5 * time.Minute
+
 // A ServerSet represents a service with a set of servers that may change over time.
 // The master lists of servers is kept as ephemeral nodes in Zookeeper.
 type ServerSet struct {
-	ZKTimeout   time.Duration
+	ZKTimeout time.Duration
+
+	// RetryDelay is the initial delay before retrying after a failure. It is doubled,
+	// with some jitter, after each consecutive failure, up to MaxRetryDelay.
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+
+	// DrainGracePeriod is how long Endpoint.Drain waits for clients to observe
+	// the STOPPING status before removing the member.
+	DrainGracePeriod time.Duration
+
+	// ACL is applied to the directories and members created by this set, including the
+	// parents like /discovery, so other sets may need it to allow world read.
+	// The default, nil, is open to everyone. See DigestACL.
+	ACL []ACL
+
+	// Auth are the credentials added to every new Zookeeper session. See DigestAuth.
+	Auth []Auth
+
+	// SnapshotFile, if set, is where watches save the members on every change.
+	// If Zookeeper can't be reached when creating a watch, the members are loaded
+	// from it and the watch is Stale until a live session is established.
+	SnapshotFile string
+
+	// WatchQuietPeriod, if set, debounces watches. After a change the members are only
+	// updated once there are no more changes for the quiet period, or WatchMaxDelay,
+	// if set, has passed since the first change. Bursts of changes, like during deploys,
+	// produce a single update.
+	WatchQuietPeriod time.Duration
+	WatchMaxDelay    time.Duration
+
+	// WatchMinEndpoints and WatchMaxRemoved, a fraction of the current endpoints, limit
+	// how much a single update can shrink the endpoints of a watch. Such an update, e.g. most
+	// members deleted by a bad deploy, is reported as a ShrinkError and the previous
+	// endpoints are kept. It's accepted once it persists for WatchShrinkHold, or
+	// DefaultWatchShrinkHold if not set. Zero limits disable them.
+	WatchMinEndpoints int
+	WatchMaxRemoved   float64
+	WatchShrinkHold   time.Duration
+
+	// Metrics receives the metrics of the endpoints and watches of this set, if set.
+	Metrics Metrics
+
 	environment Environment
 	service     string
 	zkServers   []string
+	dialer      Dialer
+
+	// watches don't create the directory, so a removed service stays removed.
+	// Used by EnvironmentWatch.
+	watchExisting bool
 }
 
 // New creates a new ServerSet object that can then be watched
@@ -58,7 +118,12 @@ func New(environment Environment, service string, zookeepers []string) *ServerSe
 	}
 
 	ss := &ServerSet{
-		ZKTimeout: DefaultZKTimeout,
+		ZKTimeout:     DefaultZKTimeout,
+		RetryDelay:    DefaultRetryDelay,
+		MaxRetryDelay: DefaultMaxRetryDelay,
+
+		DrainGracePeriod: DefaultDrainGracePeriod,
+		WatchShrinkHold:  DefaultWatchShrinkHold,
 
 		environment: environment,
 		service:     service,
@@ -68,14 +133,42 @@ func New(environment Environment, service string, zookeepers []string) *ServerSe
 	return ss
 }
 
+// NewWithDialer creates a new ServerSet that uses the given dialer to connect
+// to something other than Zookeeper, for example a MemoryStore.
+// The service name must not contain any slashes. Will panic if it does.
+func NewWithDialer(environment Environment, service string, dialer Dialer) *ServerSet {
+	ss := New(environment, service, nil)
+	ss.dialer = dialer
+
+	return ss
+}
+
 // ZookeeperServers returns the Zookeeper servers this set is using.
 // Useful to check if everything is configured correctly.
 func (ss *ServerSet) ZookeeperServers() []string {
 	return ss.zkServers
 }
 
-func (ss *ServerSet) connectToZookeeper() (*zk.Conn, <-chan zk.Event, error) {
-	return zk.Connect(ss.zkServers, ss.ZKTimeout)
+// connect opens a new session with the backend, Zookeeper unless a dialer was provided.
+func (ss *ServerSet) connect() (Backend, error) {
+	if ss.dialer != nil {
+		return ss.dialer()
+	}
+
+	return dialZookeeper(ss.zkServers, ss.ZKTimeout, ss.Auth)
+}
+
+// metrics returns the metrics to report to, a noop if none are set.
+func (ss *ServerSet) metrics() Metrics {
+	if ss.Metrics == nil {
+		return noopMetrics{}
+	}
+
+	return ss.Metrics
+}
+
+func (ss *ServerSet) newBackoff() *backoff {
+	return newBackoff(ss.RetryDelay, ss.MaxRetryDelay)
 }
 
 // directoryPath returns the base path of where all the ephemeral nodes will live.
@@ -105,48 +198,6 @@ func splitPaths(fullPath string) []string {
 }
 
 // createFullPath makes sure all the znodes are created for the parent directories
-func (ss *ServerSet) createFullPath(connection *zk.Conn) error {
-	paths := splitPaths(ss.directoryPath())
-
-	// TODO: can't we just create all? ie. mkdir -p
-	for _, key := range paths {
-		_, err := connection.Create(key, nil, 0, zk.WorldACL(zk.PermAll))
-		if err != nil && err != zk.ErrNodeExists {
-			return err
-		}
-	}
-
-	return nil
-}
-
-// structure of the data in each member znode
-// Mimics finagle serverset structure.
-type entity struct {
-	ServiceEndpoint     endpoint            `json:"serviceEndpoint"`
-	AdditionalEndpoints map[string]endpoint `json:"additionalEndpoints"` // unused
-	Status              string              `json:"status"`
+func (ss *ServerSet) createFullPath(connection Backend) error {
+	return connection.CreatePath(ss.directoryPath(), ss.ACL)
 }
-
-type endpoint struct {
-	Host string `json:"host"`
-	Port int    `json:"port"`
-}
-
-func newEntity(host string, port int) *entity {
-	return &entity{
-		ServiceEndpoint:     endpoint{host, port},
-		AdditionalEndpoints: make(map[string]endpoint),
-		Status:              statusAlive,
-	}
-}
-
-// possible endpoint statuses. Currently only concerned with ALIVE.
-const (
-	statusDead     = "DEAD"
-	statusStarting = "STARTING"
-	statusAlive    = "ALIVE"
-	statusStopping = "STOPPING"
-	statusStopped  = "STOPPED"
-	statusWarning  = "WARNING"
-	statusUnknown  = "UNKNOWN"
-)
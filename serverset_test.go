@@ -1,15 +1,66 @@
 package serversets
 
 import (
+	"errors"
+	"flag"
 	"reflect"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
+// TestServer is the Zookeeper server used when running the tests with -zookeeper.
 const TestServer = "localhost"
 
+var useZookeeper = flag.Bool("zookeeper", false, "run the tests against the Zookeeper server at TestServer")
+
+// newTestSet creates the set used by the tests. By default it is backed by a new
+// in-memory store so the tests don't require a running Zookeeper.
+func newTestSet() *ServerSet {
+	if *useZookeeper {
+		return New(Test, "gotest", []string{TestServer})
+	}
+
+	return NewWithDialer(Test, "gotest", NewMemoryStore().Dial)
+}
+
+// unreliableDialer wraps a memory store so the tests can make it unreachable.
+type unreliableDialer struct {
+	*MemoryStore
+	down int32
+}
+
+func newUnreliableSet() (*ServerSet, *unreliableDialer) {
+	d := &unreliableDialer{MemoryStore: NewMemoryStore()}
+
+	set := NewWithDialer(Test, "gotest", d.Dial)
+	set.RetryDelay = time.Millisecond
+	set.MaxRetryDelay = 10 * time.Millisecond
+
+	return set, d
+}
+
+func (d *unreliableDialer) Dial() (Backend, error) {
+	if atomic.LoadInt32(&d.down) == 1 {
+		return nil, errors.New("unreachable")
+	}
+
+	return d.MemoryStore.Dial()
+}
+
+// SetDown makes dialing fail and expires the current sessions.
+func (d *unreliableDialer) SetDown(down bool) {
+	if down {
+		atomic.StoreInt32(&d.down, 1)
+		d.ExpireSessions()
+	} else {
+		atomic.StoreInt32(&d.down, 0)
+	}
+}
+
 // This is the big run through a typical use case of add and remove and make sure it works.
 func TestServerSetAddAndRemove(t *testing.T) {
-	set := New(Test, "gotest", []string{TestServer})
+	set := newTestSet()
 	watch, err := set.Watch()
 	if err != nil {
 		panic(err)
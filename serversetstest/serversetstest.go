@@ -0,0 +1,43 @@
+// Package serversetstest provides an in-process stand-in for Zookeeper so code
+// using server sets can be tested without a running ensemble.
+package serversetstest
+
+import (
+	"github.com/strava/go.serversets"
+)
+
+// A Server is an in-process stand-in for a Zookeeper ensemble. It supports
+// ephemeral sequential members, child watches and forced session expiry.
+type Server struct {
+	*serversets.MemoryStore
+}
+
+// NewServer creates a new, empty, server.
+func NewServer() *Server {
+	return &Server{
+		MemoryStore: serversets.NewMemoryStore(),
+	}
+}
+
+// ServerSet creates a server set backed by this server. Endpoints registered
+// and watches created with it will only see other sets created by this server.
+func (s *Server) ServerSet(environment serversets.Environment, service string) *serversets.ServerSet {
+	return serversets.NewWithDialer(environment, service, s.Dial)
+}
+
+// Members returns the names of the member nodes currently registered for the service.
+// Includes all members, even those that are not alive.
+func (s *Server) Members(environment serversets.Environment, service string) ([]string, error) {
+	session, err := s.Dial()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	children, err := session.Children(serversets.BaseZnodePath(environment, service))
+	if err == serversets.ErrNoNode {
+		return []string{}, nil
+	}
+
+	return children, err
+}
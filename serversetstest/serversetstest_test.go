@@ -0,0 +1,77 @@
+package serversetstest
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/strava/go.serversets"
+)
+
+func TestServerRegisterAndWatch(t *testing.T) {
+	server := NewServer()
+	set := server.ServerSet(serversets.Test, "gotest")
+
+	watch, err := set.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch.Close()
+
+	ep, err := set.RegisterEndpoint("localhost", 1, nil)
+	if err != nil {
+		t.Fatalf("registration failure: %v", err)
+	}
+	defer ep.Close()
+
+	<-watch.Event()
+	if !reflect.DeepEqual(watch.Endpoints(), []string{"localhost:1"}) {
+		t.Errorf("server list incorrect, got %v", watch.Endpoints())
+	}
+
+	members, err := server.Members(serversets.Test, "gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(members, []string{"member_0000000000"}) {
+		t.Errorf("member list incorrect, got %v", members)
+	}
+
+	// other servers should not see this one
+	members, _ = NewServer().Members(serversets.Test, "gotest")
+	if len(members) != 0 {
+		t.Errorf("should not have members, got %v", members)
+	}
+}
+
+func TestServerExpireSessions(t *testing.T) {
+	server := NewServer()
+	set := server.ServerSet(serversets.Test, "gotest")
+
+	watch, err := set.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch.Close()
+
+	ep, err := set.RegisterEndpoint("localhost", 1, nil)
+	if err != nil {
+		t.Fatalf("registration failure: %v", err)
+	}
+	defer ep.Close()
+	<-watch.Event()
+
+	server.ExpireSessions()
+
+	// the endpoint should reregister itself with a new sequence number
+	// and the watch should pick it up on its new session.
+	for {
+		<-watch.Event()
+
+		members, _ := server.Members(serversets.Test, "gotest")
+		if reflect.DeepEqual(members, []string{"member_0000000001"}) &&
+			reflect.DeepEqual(watch.Endpoints(), []string{"localhost:1"}) {
+			break
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package serversets
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// structure of the snapshot file written by a watch
+type snapshot struct {
+	Path    string    `json:"path"`
+	Written time.Time `json:"written"`
+	Members []Member  `json:"members"`
+}
+
+// writeSnapshot saves the members to the file. It writes to a temporary
+// file first so a crash never leaves a partial snapshot behind.
+func writeSnapshot(filename, path string, members []Member) error {
+	data, err := json.Marshal(&snapshot{
+		Path:    path,
+		Written: time.Now(),
+		Members: members,
+	})
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return os.Rename(f.Name(), filename)
+}
+
+// readSnapshot loads the members saved to the file for the given path.
+func readSnapshot(filename, path string) ([]Member, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &snapshot{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+
+	if s.Path != path {
+		return nil, fmt.Errorf("snapshot %s is for %s, not %s", filename, s.Path, path)
+	}
+
+	if s.Members == nil {
+		s.Members = []Member{}
+	}
+
+	return s.Members, nil
+}
@@ -0,0 +1,118 @@
+package serversets
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWatchSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "serversets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	set, dialer := newUnreliableSet()
+	set.SnapshotFile = filepath.Join(dir, "snapshot.json")
+
+	ep, _ := set.RegisterEndpoint("localhost", 1, nil)
+
+	watch, err := set.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	members, err := readSnapshot(set.SnapshotFile, set.directoryPath())
+	if err != nil {
+		t.Fatalf("should write snapshot, got %v", err)
+	}
+
+	if len(members) != 1 || members[0].ServiceEndpoint.String() != "localhost:1" {
+		t.Errorf("incorrect snapshot, got %v", members)
+	}
+	watch.Close()
+	ep.Close()
+
+	// zookeeper is down at start, load from the snapshot
+	dialer.SetDown(true)
+
+	watch, err = set.Watch()
+	if err != nil {
+		t.Fatalf("should load snapshot, got %v", err)
+	}
+	defer watch.Close()
+
+	if !watch.Stale() {
+		t.Errorf("should be stale")
+	}
+
+	if s := watch.State(); s != StateExpired {
+		t.Errorf("should be expired, got %v", s)
+	}
+
+	if !reflect.DeepEqual(watch.Endpoints(), []string{"localhost:1"}) {
+		t.Errorf("should have endpoints from snapshot, got %v", watch.Endpoints())
+	}
+
+	// back up with a different endpoint
+	dialer.SetDown(false)
+	ep2, _ := set.RegisterEndpoint("localhost", 2, nil)
+	defer ep2.Close()
+
+	timeout := time.After(time.Second)
+	for watch.Stale() {
+		select {
+		case <-watch.Event():
+		case <-timeout:
+			t.Fatalf("should connect")
+		}
+	}
+
+	if s := watch.State(); s != StateConnected {
+		t.Errorf("should be connected, got %v", s)
+	}
+
+	if !reflect.DeepEqual(watch.Endpoints(), []string{"localhost:2"}) {
+		t.Errorf("should have live endpoints, got %v", watch.Endpoints())
+	}
+}
+
+func TestWatchSnapshotMissing(t *testing.T) {
+	set, dialer := newUnreliableSet()
+	set.SnapshotFile = filepath.Join(os.TempDir(), "serversets-does-not-exist.json")
+	dialer.SetDown(true)
+
+	if _, err := set.Watch(); err == nil {
+		t.Errorf("should return error without snapshot")
+	}
+}
+
+func TestReadSnapshotPath(t *testing.T) {
+	f, err := ioutil.TempFile("", "serversets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	if err := writeSnapshot(f.Name(), "/discovery/test/gotest", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := readSnapshot(f.Name(), "/discovery/test/other"); err == nil {
+		t.Errorf("should not load snapshot of another server set")
+	}
+
+	members, err := readSnapshot(f.Name(), "/discovery/test/gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(members) != 0 {
+		t.Errorf("should not have members, got %v", members)
+	}
+}
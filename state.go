@@ -0,0 +1,135 @@
+package serversets
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ConnState is the state of the connection of a Watch or Endpoint to Zookeeper.
+type ConnState int
+
+// Possible connection states.
+const (
+	// StateConnected means the session is live and the data is up to date.
+	StateConnected ConnState = iota
+
+	// StateDisconnected means the connection was lost, but the session may still be recovered.
+	// A watch is serving possibly stale data.
+	StateDisconnected
+
+	// StateExpired means the session is gone and a new one is being established.
+	// A watch is serving stale data and an endpoint is not registered.
+	StateExpired
+
+	// StateReregistered means a new session was established after the previous one expired,
+	// the endpoint is registered again, or the watch is up to date again.
+	StateReregistered
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnected:
+		return "connected"
+	case StateDisconnected:
+		return "disconnected"
+	case StateExpired:
+		return "expired"
+	case StateReregistered:
+		return "reregistered"
+	}
+
+	return fmt.Sprintf("ConnState(%d)", int(s))
+}
+
+// connState keeps the current state and notifies of changes.
+type connState struct {
+	lock    sync.RWMutex
+	state   ConnState
+	changes chan ConnState
+}
+
+func newConnState() *connState {
+	return &connState{
+		state:   StateConnected,
+		changes: make(chan ConnState, 10),
+	}
+}
+
+func (cs *connState) get() ConnState {
+	cs.lock.RLock()
+	defer cs.lock.RUnlock()
+
+	return cs.state
+}
+
+// set updates the state and sends it to the changes channel, if there is room.
+func (cs *connState) set(state ConnState) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	if cs.state == state {
+		return
+	}
+
+	cs.state = state
+	select {
+	case cs.changes <- state:
+	default:
+	}
+}
+
+// sessionEvent updates the state from a backend session event.
+func (cs *connState) sessionEvent(event SessionEvent) {
+	switch event.State {
+	case SessionDisconnected:
+		cs.set(StateDisconnected)
+	case SessionExpired:
+		cs.set(StateExpired)
+	case SessionConnected:
+		// connected again to the same session
+		if cs.get() == StateDisconnected {
+			cs.set(StateConnected)
+		}
+	}
+}
+
+// EndpointState is the state of the registration of an Endpoint.
+type EndpointState int
+
+// Possible endpoint states.
+const (
+	// EndpointRegistered means the endpoint is healthy and its member exists.
+	EndpointRegistered EndpointState = iota
+
+	// EndpointUnhealthy means the health check is failing and the member status is WARNING.
+	EndpointUnhealthy
+
+	// EndpointReconnecting means the session was lost, or creating the member again failed,
+	// and it's being retried. Failed updates of an existing member are retried without leaving
+	// the current state.
+	EndpointReconnecting
+
+	// EndpointClosed means the endpoint was closed and its member removed.
+	EndpointClosed
+
+	// EndpointDraining means the member status is STOPPING, or it was removed,
+	// and it's not registered again. See Endpoint.Drain.
+	EndpointDraining
+)
+
+func (s EndpointState) String() string {
+	switch s {
+	case EndpointRegistered:
+		return "registered"
+	case EndpointUnhealthy:
+		return "unhealthy"
+	case EndpointReconnecting:
+		return "reconnecting"
+	case EndpointClosed:
+		return "closed"
+	case EndpointDraining:
+		return "draining"
+	}
+
+	return fmt.Sprintf("EndpointState(%d)", int(s))
+}
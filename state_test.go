@@ -0,0 +1,57 @@
+package serversets
+
+import "testing"
+
+func TestConnState(t *testing.T) {
+	cs := newConnState()
+
+	if s := cs.get(); s != StateConnected {
+		t.Errorf("should start connected, got %v", s)
+	}
+
+	cs.sessionEvent(SessionEvent{State: SessionDisconnected})
+	cs.sessionEvent(SessionEvent{State: SessionDisconnected})
+	cs.sessionEvent(SessionEvent{State: SessionConnected})
+
+	if s := <-cs.changes; s != StateDisconnected {
+		t.Errorf("should be disconnected, got %v", s)
+	}
+
+	if s := <-cs.changes; s != StateConnected {
+		t.Errorf("should be connected, got %v", s)
+	}
+
+	cs.sessionEvent(SessionEvent{State: SessionExpired})
+	cs.set(StateReregistered)
+
+	// the initial connected event of the new session should not change anything
+	cs.sessionEvent(SessionEvent{State: SessionConnected})
+
+	if s := <-cs.changes; s != StateExpired {
+		t.Errorf("should be expired, got %v", s)
+	}
+
+	if s := <-cs.changes; s != StateReregistered {
+		t.Errorf("should be reregistered, got %v", s)
+	}
+
+	select {
+	case s := <-cs.changes:
+		t.Errorf("should not have more changes, got %v", s)
+	default:
+	}
+
+	if s := cs.get(); s != StateReregistered {
+		t.Errorf("should be reregistered, got %v", s)
+	}
+}
+
+func TestEndpointStateString(t *testing.T) {
+	if s := EndpointReconnecting.String(); s != "reconnecting" {
+		t.Errorf("incorrect string, got %v", s)
+	}
+
+	if s := EndpointState(10).String(); s != "EndpointState(10)" {
+		t.Errorf("incorrect string, got %v", s)
+	}
+}
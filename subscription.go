@@ -0,0 +1,82 @@
+package serversets
+
+// A Subscription receives the change events of a Watch on its own channel,
+// independent of any other subscriptions or readers of the watch channels.
+type Subscription struct {
+	events chan ChangeEvent
+
+	// the endpoints in the last event sent, only used by the watch goroutine
+	last []string
+}
+
+// Events returns the channel of change events for this subscription.
+// It is closed when unsubscribing or when the watch is closed.
+func (s *Subscription) Events() <-chan ChangeEvent {
+	return s.events
+}
+
+// Subscribe creates a new subscription to the change events of this watch with the given
+// channel buffer size, minimum 1. If the buffer is full, pending events are merged with the
+// next one, so a slow subscriber never misses a change or blocks the others.
+// The first event is relative to the endpoints at the time of subscribing.
+func (w *Watch) Subscribe(buffer int) *Subscription {
+	if buffer < 1 {
+		buffer = 1
+	}
+
+	w.lock.RLock()
+	endpoints := w.endpoints
+	w.lock.RUnlock()
+
+	s := &Subscription{
+		events: make(chan ChangeEvent, buffer),
+		last:   endpoints,
+	}
+
+	w.subscriptionsLock.Lock()
+	defer w.subscriptionsLock.Unlock()
+
+	if w.IsClosed() {
+		close(s.events)
+		return s
+	}
+
+	w.subscriptions[s] = struct{}{}
+	return s
+}
+
+// Unsubscribe stops and closes the subscription. Can be called multiple times.
+func (w *Watch) Unsubscribe(s *Subscription) {
+	w.subscriptionsLock.Lock()
+	defer w.subscriptionsLock.Unlock()
+
+	if _, ok := w.subscriptions[s]; !ok {
+		return
+	}
+
+	delete(w.subscriptions, s)
+	close(s.events)
+}
+
+// publish sends the change to all the subscriptions.
+func (w *Watch) publish(generation uint64, endpoints []string, members []Member) {
+	w.subscriptionsLock.Lock()
+	defer w.subscriptionsLock.Unlock()
+
+	for s := range w.subscriptions {
+		sendChange(s.events, newChangeEvent(generation, s.last, endpoints, members))
+		s.last = endpoints
+	}
+}
+
+// closeSubscriptions closes all the subscriptions, used when the watch closes.
+func (w *Watch) closeSubscriptions() {
+	w.subscriptionsLock.Lock()
+	defer w.subscriptionsLock.Unlock()
+
+	for s := range w.subscriptions {
+		close(s.events)
+	}
+
+	w.subscriptions = make(map[*Subscription]struct{})
+}
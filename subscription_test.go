@@ -0,0 +1,98 @@
+package serversets
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWatchSubscribe(t *testing.T) {
+	set := newTestSet()
+
+	watch, err := set.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch.Close()
+
+	sub1 := watch.Subscribe(10)
+	sub2 := watch.Subscribe(0)
+
+	ep1, err := set.RegisterEndpoint("localhost", 1001, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep1.Close()
+
+	// everyone gets the event, including readers of the event channel
+	<-watch.Event()
+	for _, sub := range []*Subscription{sub1, sub2} {
+		event := <-sub.Events()
+		if !reflect.DeepEqual(event.Added, []string{"localhost:1001"}) {
+			t.Errorf("incorrect change, got %v", event.Added)
+		}
+	}
+
+	watch.Unsubscribe(sub2)
+	watch.Unsubscribe(sub2)
+
+	if _, ok := <-sub2.Events(); ok {
+		t.Errorf("should close channel on unsubscribe")
+	}
+
+	ep2, err := set.RegisterEndpoint("localhost", 1002, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep2.Close()
+
+	event := <-sub1.Events()
+	if !reflect.DeepEqual(event.Added, []string{"localhost:1002"}) {
+		t.Errorf("incorrect change, got %v", event.Added)
+	}
+
+	// new subscriptions are relative to the current endpoints
+	sub3 := watch.Subscribe(1)
+	ep1.Close()
+
+	event = <-sub3.Events()
+	if len(event.Added) != 0 || !reflect.DeepEqual(event.Removed, []string{"localhost:1001"}) {
+		t.Errorf("incorrect change, got %v %v", event.Added, event.Removed)
+	}
+
+	watch.Close()
+
+	// should close subscriptions when watch closes, after pending events
+	for range sub1.Events() {
+	}
+
+	if _, ok := <-watch.Subscribe(1).Events(); ok {
+		t.Errorf("should close subscriptions to closed watch")
+	}
+}
+
+func TestWatcherEvents(t *testing.T) {
+	set := newTestSet()
+
+	watch, err := set.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch.Close()
+
+	events, stop := WatcherEvents(watch)
+
+	ep, err := set.RegisterEndpoint("localhost", 1001, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Close()
+
+	// the event channel of the watch is not taken
+	<-watch.Event()
+	<-events
+
+	stop()
+	if _, ok := <-events; ok {
+		t.Errorf("should close channel when stopped")
+	}
+}
@@ -3,8 +3,10 @@ package thriftset
 import (
 	"errors"
 	"io"
+	"sync"
 	"time"
 
+	"github.com/strava/go.serversets"
 	"github.com/strava/go.serversets/internal/endpoints"
 
 	"github.com/apache/thrift/lib/go/thrift"
@@ -57,6 +59,8 @@ type Watcher interface {
 
 // ThriftSet defines a set of thift connections. It loadbalances over
 // the set of hosts using the "least active connections" strategy.
+// If the watch has the weights of the endpoints, like serversets.Watch,
+// active connections are relative to the weight of each endpoint.
 type ThriftSet struct {
 	watch Watcher
 
@@ -71,6 +75,9 @@ type ThriftSet struct {
 
 	endpoints *endpoints.Set
 
+	lock     sync.Mutex // guards the locality
+	locality *serversets.Locality
+
 	// This channel will get an event when zookeeper updates things
 	// calling SetEndpoints will not trigger this type of event.
 	event         chan struct{}
@@ -107,8 +114,10 @@ func New(watch Watcher) *ThriftSet {
 	ts.endpoints = endpoints.NewSet(ts)
 	ts.resetEndpoints()
 
+	events, stop := serversets.WatcherEvents(watch)
 	go func() {
 		defer func() {
+			stop()
 			close(ts.watcherClosed) // the Close method waits for this goroutine to quit.
 			watcherClosed()
 		}()
@@ -117,7 +126,7 @@ func New(watch Watcher) *ThriftSet {
 			select {
 			case <-ts.done:
 				return
-			case <-watch.Event():
+			case <-events:
 				ts.StatsD.Count(sdZKEvent, 1.0)
 
 				ts.resetEndpoints()
@@ -201,6 +210,23 @@ func (ts *ThriftSet) SetTimeout(t time.Duration) {
 	ts.timeout = t
 }
 
+// SetLocality makes the set prefer the endpoints of the watch in the same zone,
+// spilling over to the others if there are not enough, see serversets.Locality.
+// The watch must have the members, like serversets.Watch. Nil uses all the endpoints again.
+func (ts *ThriftSet) SetLocality(locality *serversets.Locality) {
+	var l *serversets.Locality
+	if locality != nil {
+		c := *locality
+		l = &c
+	}
+
+	ts.lock.Lock()
+	ts.locality = l
+	ts.lock.Unlock()
+
+	ts.resetEndpoints()
+}
+
 // Event returns the event channel. This channel will get an object
 // whenever something changes with the list of endpoints.
 // Mostly used for testing as this will trigger after all the watch events handling completes.
@@ -265,7 +291,18 @@ func (ts *ThriftSet) GetConn() (*Conn, error) {
 // resetEndpoints closes idle connections on old endpoints.
 func (ts *ThriftSet) resetEndpoints() {
 	hosts := ts.watch.Endpoints()
-	ts.endpoints.SetEndpoints(hosts)
+
+	ts.lock.Lock()
+	locality := ts.locality
+	ts.lock.Unlock()
+
+	if locality != nil {
+		if members := serversets.WatcherMembers(ts.watch); members != nil {
+			hosts = locality.Select(hosts, members)
+		}
+	}
+
+	ts.endpoints.SetWeightedEndpoints(hosts, serversets.WatcherWeights(ts.watch))
 }
 
 // Release puts the connection back in the pool and allows others to use it.
@@ -4,6 +4,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/strava/go.serversets"
 	"github.com/strava/go.serversets/fixedset"
 	"github.com/strava/go.serversets/internal/endpoints"
 
@@ -166,3 +167,74 @@ func TestConnClose(t *testing.T) {
 		t.Errorf("should get another socket because first closed and not returned")
 	}
 }
+
+type weightedWatch struct {
+	*fixedset.FixedSet
+	weights map[string]int
+}
+
+func (w *weightedWatch) Weights() map[string]int {
+	return w.weights
+}
+
+func TestThriftSetWeights(t *testing.T) {
+	socketBuilder = func(string, time.Duration) (*thrift.TSocket, error) {
+		return &thrift.TSocket{}, nil
+	}
+
+	ts := New(&weightedWatch{
+		FixedSet: fixedset.New([]string{"endpoint1", "endpoint2"}),
+		weights:  map[string]int{"endpoint1": 3},
+	})
+	defer ts.Close()
+
+	counts := make(map[string]int)
+	for i := 0; i < 4; i++ {
+		c, err := ts.GetConn()
+		if err != nil {
+			t.Fatalf("should have server, got %v", err)
+		}
+		counts[c.parent.Endpoint]++
+	}
+
+	if counts["endpoint1"] != 3 || counts["endpoint2"] != 1 {
+		t.Errorf("should balance by weight, got %v", counts)
+	}
+}
+
+type zonedWatch struct {
+	*fixedset.FixedSet
+	members []serversets.Member
+}
+
+func (w *zonedWatch) Members() []serversets.Member {
+	return w.members
+}
+
+func TestThriftSetLocality(t *testing.T) {
+	socketBuilder = func(string, time.Duration) (*thrift.TSocket, error) {
+		return &thrift.TSocket{}, nil
+	}
+
+	ts := New(&zonedWatch{
+		FixedSet: fixedset.New([]string{"endpoint1:1", "endpoint2:1"}),
+		members: []serversets.Member{
+			{ServiceEndpoint: serversets.MemberEndpoint{Host: "endpoint1", Port: 1}, Status: serversets.StatusAlive, Zone: "a"},
+			{ServiceEndpoint: serversets.MemberEndpoint{Host: "endpoint2", Port: 1}, Status: serversets.StatusAlive, Zone: "b"},
+		},
+	})
+	defer ts.Close()
+
+	ts.SetLocality(&serversets.Locality{Zone: "b"})
+
+	for i := 0; i < 3; i++ {
+		c, err := ts.GetConn()
+		if err != nil {
+			t.Fatalf("should have server, got %v", err)
+		}
+
+		if c.parent.Endpoint != "endpoint2:1" {
+			t.Errorf("should only use the local zone, got %v", c.parent.Endpoint)
+		}
+	}
+}
@@ -3,14 +3,10 @@ package serversets
 import (
 	"encoding/json"
 	"fmt"
-	"net"
 	"sort"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
-
-	"github.com/samuel/go-zookeeper/zk"
 )
 
 const (
@@ -18,6 +14,19 @@ const (
 	SOH = "\x01"
 )
 
+// A ShrinkError is reported by a watch when an update removes more endpoints than allowed
+// by WatchMinEndpoints or WatchMaxRemoved. The previous endpoints are kept meanwhile.
+type ShrinkError struct {
+	Previous int
+	Current  int
+	Removed  int
+}
+
+func (e *ShrinkError) Error() string {
+	return fmt.Sprintf("serversets: update removes %d of %d endpoints, leaving %d, keeping the previous list",
+		e.Removed, e.Previous, e.Current)
+}
+
 // A Watch keeps tabs on a server set in Zookeeper and notifies
 // via the Event() channel when the list of servers changes.
 // The list of servers is updated automatically and will be up to date when the Event is sent.
@@ -27,90 +36,204 @@ type Watch struct {
 	LastEvent  time.Time
 	EventCount int
 	event      chan struct{}
+	changes    *Subscription
+	errs       chan error
+	state      *connState
 
 	done chan struct{} // used for closing
 	wg   sync.WaitGroup
 
-	// lock for read/writing the endpoints slice
+	// lock for read/writing the endpoints and members slices
 	lock      sync.RWMutex
 	endpoints []string
+	members   []Member
+	stale     bool
+
+	subscriptionsLock sync.Mutex
+	subscriptions     map[*Subscription]struct{}
+
+	// only used by the goroutine sending events
+	generation uint64
+
+	// only used by the watch goroutine, the member names of the last rewatch
+	// and the members with a data watch.
+	keys    []string
+	watched map[string]struct{}
+
+	// gets the name of the members whose data watch fired
+	dataEvents chan string
 }
 
 // Watch creates a new watch on this server set. Changes to the set will
 // update watch.Endpoints() and an event will be sent to watch.Event right after that happens.
 func (ss *ServerSet) Watch() (*Watch, error) {
 	watch := &Watch{
-		serverSet: ss,
-		done:      make(chan struct{}),
-		event:     make(chan struct{}, 1),
-	}
-
-	connection, sessionEvents, err := ss.connectToZookeeper()
-	if err != nil {
-		return nil, err
-	}
-
-	keys, watchEvents, err := watch.watch(connection)
-	if err != nil {
-		return nil, err
+		serverSet:     ss,
+		done:          make(chan struct{}),
+		event:         make(chan struct{}, 1),
+		subscriptions: make(map[*Subscription]struct{}),
+		errs:          make(chan error, 10),
+		state:         newConnState(),
+		watched:       make(map[string]struct{}),
+		dataEvents:    make(chan string),
 	}
 
-	watch.endpoints, err = watch.updateEndpoints(connection, keys)
+	connection, watchEvents, err := watch.start()
 	if err != nil {
-		return nil, err
+		if err := watch.loadSnapshot(err); err != nil {
+			return nil, err
+		}
 	}
+	watch.changes = watch.Subscribe(1)
 
 	// spawn a goroutine to deal with session disconnects and watch events
 	watch.wg.Add(1)
 	go func() {
 		defer watch.wg.Done()
+
+		backoff := ss.newBackoff()
+		var (
+			retry <-chan time.Time
+
+			// debouncing, waiting for changes to settle
+			quiet    <-chan time.Time
+			deadline <-chan time.Time
+
+			// the members of an update removing too many endpoints, until it is accepted
+			held []Member
+			hold <-chan time.Time
+		)
+
+		if connection == nil {
+			// loaded from the snapshot, keep trying to connect
+			retry = time.After(backoff.next())
+		}
+
 		for {
+			var sessionEvents <-chan SessionEvent
+			if connection != nil {
+				sessionEvents = connection.SessionEvents()
+			}
+
+			changed := false
 			select {
 			case event := <-sessionEvents:
-				if event.Type == zk.EventSession && event.State == zk.StateExpired {
-					connection.Close()
-					connection = nil
-				}
-			case <-watchEvents:
-				keys, watchEvents, err = watch.watch(connection)
-				if err != nil {
-					panic(fmt.Errorf("unable to rewatch endpoint after znode event: %v", err))
+				watch.state.sessionEvent(event)
+				if event.State != SessionExpired {
+					continue
 				}
 
-				endpoints, err := watch.updateEndpoints(connection, keys)
-				if err != nil {
-					panic(fmt.Errorf("unable to updated endpoint list after znode event: %v", err))
+				ss.metrics().Count(MetricSessionExpirations, 1)
+				connection.Close()
+				connection = nil
+				watchEvents = nil
+				watch.watched = make(map[string]struct{})
+			case <-watchEvents:
+				watchEvents = nil
+				changed = true
+			case key := <-watch.dataEvents:
+				if !watch.dataChanged(connection, key) {
+					continue
 				}
-
-				watch.lock.Lock()
-				watch.endpoints = endpoints
-				watch.lock.Unlock()
-
+				changed = true
+			case <-quiet:
+			case <-deadline:
+			case <-hold:
+				// the shrink persisted, accept it
+				watch.setMembers(held)
+				watch.saveSnapshot(held)
 				watch.triggerEvent()
 
+				held, hold = nil, nil
+				continue
+			case <-retry:
+				retry = nil
 			case <-watch.done:
-				connection.Close()
+				if connection != nil {
+					connection.Close()
+				}
 				return
 			}
 
-			if connection == nil {
-				connection, sessionEvents, err = ss.connectToZookeeper()
-				if err != nil {
-					panic(fmt.Errorf("unable to reconnect to zookeeper after session expired: %v", err))
+			if retry != nil {
+				// already failing, wait for the retry to rewatch
+				continue
+			}
+
+			// the watches fire when the session expires, but the expired
+			// event is sent first, so check for it before rewatching.
+			if changed && connection != nil && sessionExpired(connection) {
+				ss.metrics().Count(MetricSessionExpirations, 1)
+				watch.state.set(StateExpired)
+				connection.Close()
+				connection = nil
+				watchEvents = nil
+				watch.watched = make(map[string]struct{})
+			} else if changed && connection != nil && ss.WatchQuietPeriod > 0 {
+				// keep watching, so every change restarts the quiet period,
+				// but only read the members once the changes settle.
+				if watchEvents == nil {
+					watch.keys, watchEvents, err = watch.watch(connection)
 				}
 
-				keys, watchEvents, err = watch.watch(connection)
-				if err != nil {
-					panic(fmt.Errorf("unable to reregister endpoint after session expired: %v", err))
+				if err == nil {
+					quiet = time.After(ss.WatchQuietPeriod)
+					if deadline == nil && ss.WatchMaxDelay > 0 {
+						deadline = time.After(ss.WatchMaxDelay)
+					}
+					continue
 				}
 
-				watch.endpoints, err = watch.updateEndpoints(connection, keys)
-				if err != nil {
-					panic(fmt.Errorf("unable to update endpoint list after session expired: %v", err))
+				// refresh starts over
+				watchEvents = nil
+			}
+
+			quiet, deadline = nil, nil
+
+			// on failure the last known endpoints are kept until a retry succeeds.
+			reconnect := connection == nil
+			var members []Member
+			connection, watchEvents, members, err = watch.refresh(connection, watchEvents)
+			if err != nil {
+				watch.watched = make(map[string]struct{})
+				watch.state.set(StateExpired)
+				reportError(watch.errs, err)
+				retry = time.After(backoff.next())
+				continue
+			}
+
+			if watch.Stale() {
+				watch.lock.Lock()
+				watch.stale = false
+				watch.lock.Unlock()
+
+				watch.state.set(StateConnected)
+			} else if reconnect {
+				watch.state.set(StateReregistered)
+			}
+
+			backoff.reset()
+
+			if err := watch.checkShrink(members); err != nil {
+				if held == nil {
+					ss.metrics().Count(MetricShrinksHeld, 1)
+					reportError(watch.errs, err)
+
+					d := ss.WatchShrinkHold
+					if d <= 0 {
+						d = DefaultWatchShrinkHold
+					}
+					hold = time.After(d)
 				}
 
-				watch.triggerEvent()
+				held = members
+				continue
 			}
+			held, hold = nil, nil
+
+			watch.setMembers(members)
+			watch.saveSnapshot(members)
+			watch.triggerEvent()
 		}
 	}()
 
@@ -125,12 +248,67 @@ func (w *Watch) Endpoints() []string {
 	return w.endpoints
 }
 
+// Members returns the current list of members of this server set, sorted by sequence number.
+// Unlike Endpoints, this includes members that are not alive.
+func (w *Watch) Members() []Member {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+
+	return w.members
+}
+
+// Weights returns the weight of each of the current endpoints, as advertised by their members.
+// Members that don't advertise one have a weight of 1.
+func (w *Watch) Weights() map[string]int {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+
+	return aliveWeights(w.members)
+}
+
+// Stale returns true if the endpoints were loaded from the snapshot file because
+// Zookeeper could not be reached, and a live session has not been established since.
+func (w *Watch) Stale() bool {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+
+	return w.stale
+}
+
 // Event returns the event channel. This channel will get an object
 // whenever something changes with the list of endpoints.
 func (w *Watch) Event() <-chan struct{} {
 	return w.event
 }
 
+// Changes returns the channel of typed change events. Each event has the endpoints
+// added and removed, and the full new snapshot. Unlike Event(), no change is lost,
+// events not read are merged with the next one.
+// This channel should only have one reader, use Subscribe for multiple consumers.
+func (w *Watch) Changes() <-chan ChangeEvent {
+	return w.changes.Events()
+}
+
+// Errors returns a channel that gets the errors encountered while reconnecting
+// to Zookeeper or rewatching the server set. The watch keeps retrying, with backoff,
+// and serving the last known endpoints after an error.
+// Errors are dropped if the channel is not read.
+func (w *Watch) Errors() <-chan error {
+	return w.errs
+}
+
+// State returns the current state of the connection to Zookeeper.
+// StateConnected and StateReregistered mean the watch is serving up to date endpoints.
+func (w *Watch) State() ConnState {
+	return w.state.get()
+}
+
+// StateChanges returns a channel that gets the new state whenever the state
+// of the connection to Zookeeper changes. Changes are dropped if the channel is not read.
+func (w *Watch) StateChanges() <-chan ConnState {
+	return w.state.changes
+}
+
 // Close blocks until the underlying Zookeeper connection is closed.
 func (w *Watch) Close() {
 	select {
@@ -144,8 +322,11 @@ func (w *Watch) Close() {
 	w.wg.Wait()
 
 	// the goroutine watching for events must be terminted
-	// before we close this channel, since it might still be sending events.
+	// before we close these channels, since it might still be sending events.
 	close(w.event)
+	close(w.errs)
+	close(w.state.changes)
+	w.closeSubscriptions()
 	return
 }
 
@@ -161,49 +342,216 @@ func (w *Watch) IsClosed() bool {
 	return false
 }
 
-// watch creates the actual Zookeeper watch.
-func (w *Watch) watch(connection *zk.Conn) ([]string, <-chan zk.Event, error) {
-	err := w.serverSet.createFullPath(connection)
+// start connects, watches and gets the initial members.
+func (w *Watch) start() (Backend, <-chan struct{}, error) {
+	connection, err := w.serverSet.connect()
 	if err != nil {
 		return nil, nil, err
 	}
 
-	children, _, events, err := connection.ChildrenW(w.serverSet.directoryPath())
-	return children, events, err
+	keys, watchEvents, err := w.watch(connection)
+	if err != nil {
+		connection.Close()
+		return nil, nil, err
+	}
+	w.keys = keys
+
+	members, err := w.updateMembers(connection, keys)
+	if err != nil {
+		connection.Close()
+		return nil, nil, err
+	}
+
+	w.setMembers(members)
+	w.saveSnapshot(members)
+
+	return connection, watchEvents, nil
+}
+
+// loadSnapshot loads the members from the snapshot file, if there is one, after failing
+// to start with the given error. Returns the original error if it can't be loaded.
+func (w *Watch) loadSnapshot(err error) error {
+	if w.serverSet.SnapshotFile == "" {
+		return err
+	}
+
+	members, serr := readSnapshot(w.serverSet.SnapshotFile, w.serverSet.directoryPath())
+	if serr != nil {
+		return err
+	}
+
+	w.setMembers(members)
+	w.stale = true
+	w.state.set(StateExpired)
+	reportError(w.errs, err)
+
+	return nil
+}
+
+// saveSnapshot writes the members to the snapshot file, if there is one.
+func (w *Watch) saveSnapshot(members []Member) {
+	if w.serverSet.SnapshotFile == "" {
+		return
+	}
+
+	err := writeSnapshot(w.serverSet.SnapshotFile, w.serverSet.directoryPath(), members)
+	if err != nil {
+		reportError(w.errs, fmt.Errorf("unable to write snapshot: %v", err))
+	}
+}
+
+// refresh reconnects and rewatches, if necessary, and reads the members.
+// If still watching, the members are read from the keys of that watch.
+// On error the connection is closed, and nil returned, so the next attempt starts over.
+func (w *Watch) refresh(connection Backend, watchEvents <-chan struct{}) (Backend, <-chan struct{}, []Member, error) {
+	start := time.Now()
+
+	var err error
+	if connection == nil {
+		w.serverSet.metrics().Count(MetricReconnects, 1)
+		connection, err = w.serverSet.connect()
+		if err != nil {
+			w.serverSet.metrics().Count(MetricReconnectErrors, 1)
+			return nil, nil, nil, fmt.Errorf("unable to reconnect to zookeeper: %v", err)
+		}
+	}
+
+	if watchEvents == nil {
+		w.keys, watchEvents, err = w.watch(connection)
+		if err != nil {
+			connection.Close()
+			return nil, nil, nil, fmt.Errorf("unable to rewatch endpoints: %v", err)
+		}
+	}
+
+	members, err := w.updateMembers(connection, w.keys)
+	if err != nil {
+		connection.Close()
+		return nil, nil, nil, fmt.Errorf("unable to update endpoint list: %v", err)
+	}
+
+	w.serverSet.metrics().Timing(MetricWatchRefreshTime, time.Since(start))
+	return connection, watchEvents, members, nil
 }
 
-func (w *Watch) updateEndpoints(connection *zk.Conn, keys []string) ([]string, error) {
-	endpoints := make([]string, 0, len(keys))
+// checkShrink returns an error if the members remove more endpoints than
+// allowed by the server set, compared to the current endpoints.
+func (w *Watch) checkShrink(members []Member) error {
+	ss := w.serverSet
+	if ss.WatchMinEndpoints <= 0 && ss.WatchMaxRemoved <= 0 {
+		return nil
+	}
+
+	w.lock.RLock()
+	previous := w.endpoints
+	w.lock.RUnlock()
+
+	current := aliveEndpoints(members)
+	_, removed := diffEndpoints(previous, current)
+	if len(removed) == 0 {
+		return nil
+	}
+
+	if len(current) < ss.WatchMinEndpoints ||
+		(ss.WatchMaxRemoved > 0 && float64(len(removed)) > ss.WatchMaxRemoved*float64(len(previous))) {
+		return &ShrinkError{
+			Previous: len(previous),
+			Current:  len(current),
+			Removed:  len(removed),
+		}
+	}
+
+	return nil
+}
+
+// watch creates the actual Zookeeper watch.
+func (w *Watch) watch(connection Backend) ([]string, <-chan struct{}, error) {
+	if !w.serverSet.watchExisting {
+		err := w.serverSet.createFullPath(connection)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return connection.ChildrenW(w.serverSet.directoryPath())
+}
+
+func (w *Watch) updateMembers(connection Backend, keys []string) ([]Member, error) {
+	members := make([]Member, 0, len(keys))
 
 	for _, k := range keys {
 		if !strings.HasPrefix(k, MemberPrefix) {
 			continue
 		}
 
-		e, err := w.getEndpoint(connection, k)
+		m, err := w.getMember(connection, k)
 		if err != nil {
 			return nil, err
 		}
 
-		if e == nil {
+		if m == nil {
 			// znode not found
 			continue
 		}
 
-		if e.Status == statusAlive {
-			endpoints = append(endpoints, net.JoinHostPort(e.ServiceEndpoint.Host, strconv.Itoa(e.ServiceEndpoint.Port)))
-		}
+		members = append(members, *m)
 	}
 
+	sort.Sort(bySequence(members))
+	return members, nil
+}
+
+// setMembers updates the members and the endpoints of the alive ones.
+func (w *Watch) setMembers(members []Member) {
+	endpoints := aliveEndpoints(members)
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.members = members
+	w.endpoints = endpoints
+}
+
+// aliveEndpoints returns the sorted endpoints of the alive members.
+func aliveEndpoints(members []Member) []string {
+	endpoints := make([]string, 0, len(members))
+	for _, m := range members {
+		if m.Status == StatusAlive {
+			endpoints = append(endpoints, m.ServiceEndpoint.String())
+		}
+	}
 	sort.Strings(endpoints)
-	return endpoints, nil
 
+	return endpoints
+}
+
+// aliveWeights returns the weights of the endpoints of the alive members.
+// If several members have the same endpoint the highest weight is used.
+func aliveWeights(members []Member) map[string]int {
+	weights := make(map[string]int, len(members))
+	for _, m := range members {
+		if m.Status != StatusAlive {
+			continue
+		}
+
+		weight := m.Weight
+		if weight < 1 {
+			weight = 1
+		}
+
+		e := m.ServiceEndpoint.String()
+		if weight > weights[e] {
+			weights[e] = weight
+		}
+	}
+
+	return weights
 }
 
-func (w *Watch) getEndpoint(connection *zk.Conn, key string) (*entity, error) {
+func (w *Watch) getMember(connection Backend, key string) (*Member, error) {
 
-	data, _, err := connection.Get(w.serverSet.directoryPath() + "/" + key)
-	if err == zk.ErrNoNode {
+	data, stat, err := w.get(connection, key)
+	if err == ErrNoNode {
 		return nil, nil
 	}
 
@@ -218,19 +566,93 @@ func (w *Watch) getEndpoint(connection *zk.Conn, key string) (*entity, error) {
 	// FIXME handle very rare cases where Get returns the
 	// SOH control character instead of the actual value
 	if string(data) == SOH {
-		return w.getEndpoint(connection, key)
+		return w.getMember(connection, key)
 	}
 
 	e := &entity{}
 	err = json.Unmarshal(data, &e)
 	if err != nil {
+		w.serverSet.metrics().Count(MetricParseErrors, 1)
 		return nil, err
 	}
 
-	return e, err
+	return newMember(key, e, stat), nil
 }
 
-// triggerEvent will queue up something in the Event channel if there isn't already something there.
+// get reads the data of the member, and watches it for changes if not already watching.
+func (w *Watch) get(connection Backend, key string) ([]byte, *Stat, error) {
+	p := w.serverSet.directoryPath() + "/" + key
+	if _, ok := w.watched[key]; ok {
+		return connection.Get(p)
+	}
+
+	data, stat, changed, err := connection.GetW(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	w.watched[key] = struct{}{}
+
+	go func() {
+		select {
+		case <-changed:
+		case <-w.done:
+			return
+		}
+
+		select {
+		case w.dataEvents <- key:
+		case <-w.done:
+		}
+	}()
+
+	return data, stat, nil
+}
+
+// dataChanged forgets the data watches that fired, the one for the key and any other pending,
+// since all of them fire at once when the session ends. Returns true if the data of any of those
+// members changed. Deleted members are left to the child watch.
+func (w *Watch) dataChanged(connection Backend, key string) bool {
+	keys := []string{key}
+	for pending := true; pending; {
+		select {
+		case key := <-w.dataEvents:
+			keys = append(keys, key)
+		default:
+			pending = false
+		}
+	}
+
+	changed := false
+	for _, key := range keys {
+		delete(w.watched, key)
+
+		if connection == nil || changed {
+			continue
+		}
+
+		_, _, err := connection.Get(w.serverSet.directoryPath() + "/" + key)
+		changed = err != ErrNoNode
+	}
+
+	return changed
+}
+
+// sessionExpired checks, without blocking, if the session has a pending expired event.
+func sessionExpired(connection Backend) bool {
+	for {
+		select {
+		case event := <-connection.SessionEvents():
+			if event.State == SessionExpired {
+				return true
+			}
+		default:
+			return false
+		}
+	}
+}
+
+// triggerEvent will queue up something in the Event channel if there isn't already something there,
+// and send the change event to all the subscriptions.
 func (w *Watch) triggerEvent() {
 	w.EventCount++
 	w.LastEvent = time.Now()
@@ -239,4 +661,16 @@ func (w *Watch) triggerEvent() {
 	case w.event <- struct{}{}:
 	default:
 	}
+
+	w.lock.RLock()
+	endpoints, members := w.endpoints, w.members
+	w.lock.RUnlock()
+
+	metrics := w.serverSet.metrics()
+	metrics.Count(MetricWatchEvents, 1)
+	metrics.Gauge(MetricWatchEndpoints, len(endpoints))
+	metrics.Gauge(MetricWatchMembers, len(members))
+
+	w.generation++
+	w.publish(w.generation, endpoints, members)
 }
@@ -1,12 +1,15 @@
 package serversets
 
 import (
+	"encoding/json"
+	"reflect"
 	"sort"
 	"testing"
+	"time"
 )
 
 func TestWatchSortEndpoints(t *testing.T) {
-	set := New(Test, "gotest", []string{TestServer})
+	set := newTestSet()
 
 	watch, err := set.Watch()
 	if err != nil {
@@ -46,7 +49,7 @@ func TestWatchSortEndpoints(t *testing.T) {
 }
 
 func TestWatchUpdateEndpoints(t *testing.T) {
-	set := New(Test, "gotest", []string{TestServer})
+	set := newTestSet()
 
 	watch, err := set.Watch()
 	if err != nil {
@@ -61,24 +64,24 @@ func TestWatchUpdateEndpoints(t *testing.T) {
 	defer ep1.Close()
 	<-watch.Event()
 
-	conn, _, err := set.connectToZookeeper()
+	conn, err := set.connect()
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer conn.Close()
 
-	eps, err := watch.updateEndpoints(conn, []string{MemberPrefix + "random"})
+	members, err := watch.updateMembers(conn, []string{MemberPrefix + "random"})
 	if err != nil {
 		t.Fatalf("should not have error, got %v", err)
 	}
 
-	if len(eps) != 0 {
-		t.Errorf("should not have any endpoints, got %v", eps)
+	if len(members) != 0 {
+		t.Errorf("should not have any members, got %v", members)
 	}
 }
 
 func TestWatchIsClosed(t *testing.T) {
-	set := New(Test, "gotest", []string{TestServer})
+	set := newTestSet()
 	watch, err := set.Watch()
 	if err != nil {
 		t.Fatal(err)
@@ -92,7 +95,7 @@ func TestWatchIsClosed(t *testing.T) {
 }
 
 func TestWatchMultipleClose(t *testing.T) {
-	set := New(Test, "gotest", []string{TestServer})
+	set := newTestSet()
 	watch, err := set.Watch()
 	if err != nil {
 		t.Fatal(err)
@@ -104,7 +107,7 @@ func TestWatchMultipleClose(t *testing.T) {
 }
 
 func TestWatchTriggerEvent(t *testing.T) {
-	set := New(Test, "gotest", []string{TestServer})
+	set := newTestSet()
 	watch, err := set.Watch()
 	if err != nil {
 		t.Fatal(err)
@@ -116,3 +119,410 @@ func TestWatchTriggerEvent(t *testing.T) {
 	watch.triggerEvent()
 	watch.triggerEvent()
 }
+
+func TestWatchMembers(t *testing.T) {
+	set := newTestSet()
+
+	watch, err := set.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch.Close()
+
+	ep, err := set.RegisterEndpoint("localhost", 1001, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Close()
+	<-watch.Event()
+
+	// a member that is not alive, written directly
+	conn, err := set.connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	data := `{"serviceEndpoint":{"host":"localhost","port":1002},` +
+		`"additionalEndpoints":{"admin":{"host":"localhost","port":9990}},` +
+		`"status":"STARTING","shard":2}`
+	_, err = conn.CreateMember(set.directoryPath()+"/"+MemberPrefix, []byte(data), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-watch.Event()
+
+	members := watch.Members()
+	if len(members) != 2 {
+		t.Fatalf("should have 2 members, got %v", members)
+	}
+
+	if members[0].Sequence >= members[1].Sequence {
+		t.Errorf("members should be sorted by sequence, got %v", members)
+	}
+
+	if m := WatcherMembers(watch); !reflect.DeepEqual(m, members) {
+		t.Errorf("should get the members of the watcher, got %v", m)
+	}
+
+	if v := members[0].ServiceEndpoint.String(); v != "localhost:1001" {
+		t.Errorf("incorrect endpoint, got %v", v)
+	}
+
+	if members[0].Created.IsZero() {
+		t.Errorf("should have created time")
+	}
+
+	m := members[1]
+	if m.Status != StatusStarting {
+		t.Errorf("incorrect status, got %v", m.Status)
+	}
+
+	if v := m.AdditionalEndpoints["admin"].String(); v != "localhost:9990" {
+		t.Errorf("incorrect admin endpoint, got %v", v)
+	}
+
+	if m.Shard == nil || *m.Shard != 2 {
+		t.Errorf("incorrect shard, got %v", m.Shard)
+	}
+
+	// only alive members are endpoints
+	if eps := watch.Endpoints(); len(eps) != 1 || eps[0] != "localhost:1001" {
+		t.Errorf("should only have alive endpoint, got %v", eps)
+	}
+}
+
+func TestWatchWeights(t *testing.T) {
+	set := newTestSet()
+
+	watch, err := set.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch.Close()
+
+	ep1, err := set.RegisterEndpointWithOptions("localhost", 1001, nil, EndpointOptions{Weight: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep1.Close()
+	<-watch.Event()
+
+	ep2, err := set.RegisterEndpoint("localhost", 1002, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep2.Close()
+	<-watch.Event()
+
+	members := watch.Members()
+	if len(members) != 2 || members[0].Weight != 3 || members[1].Weight != 0 {
+		t.Errorf("should advertise weight, got %v", members)
+	}
+
+	weights := watch.Weights()
+	if len(weights) != 2 || weights["localhost:1001"] != 3 || weights["localhost:1002"] != 1 {
+		t.Errorf("incorrect weights, got %v", weights)
+	}
+
+	if w := WatcherWeights(watch); !reflect.DeepEqual(w, weights) {
+		t.Errorf("should get the weights of the watcher, got %v", w)
+	}
+}
+
+func TestWatchReconnect(t *testing.T) {
+	set, dialer := newUnreliableSet()
+
+	watch, err := set.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch.Close()
+
+	ep, err := set.RegisterEndpoint("localhost", 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Close()
+	<-watch.Event()
+
+	if s := watch.State(); s != StateConnected {
+		t.Errorf("should be connected, got %v", s)
+	}
+
+	dialer.SetDown(true)
+
+	if err := <-watch.Errors(); err == nil {
+		t.Errorf("should report reconnect error")
+	}
+
+	if s := <-watch.StateChanges(); s != StateExpired {
+		t.Errorf("should be expired, got %v", s)
+	}
+
+	// should keep serving the last known endpoints
+	if eps := watch.Endpoints(); len(eps) != 1 || eps[0] != "localhost:1" {
+		t.Errorf("should keep last known endpoints, got %v", eps)
+	}
+
+	dialer.SetDown(false)
+
+	// the endpoint will reregister with a new sequence number
+	for {
+		<-watch.Event()
+
+		members := watch.Members()
+		if len(members) == 1 && members[0].Sequence == 1 {
+			break
+		}
+	}
+
+	if s := <-watch.StateChanges(); s != StateReregistered {
+		t.Errorf("should be reregistered, got %v", s)
+	}
+}
+
+func TestWatchChanges(t *testing.T) {
+	set := newTestSet()
+
+	watch, err := set.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch.Close()
+
+	ep1, err := set.RegisterEndpoint("localhost", 1001, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep1.Close()
+
+	event := <-watch.Changes()
+	if !reflect.DeepEqual(event.Added, []string{"localhost:1001"}) || len(event.Removed) != 0 {
+		t.Errorf("incorrect change, got %v %v", event.Added, event.Removed)
+	}
+
+	if len(event.Members) != 1 {
+		t.Errorf("should have member snapshot, got %v", event.Members)
+	}
+
+	// these two changes may be merged, but the result is the same
+	ep2, err := set.RegisterEndpoint("localhost", 1002, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep2.Close()
+	ep1.Close()
+
+	var added, removed []string
+	generation := event.Generation
+	for {
+		event = <-watch.Changes()
+		if event.Generation <= generation {
+			t.Errorf("generation should increase, got %v", event.Generation)
+		}
+		generation = event.Generation
+
+		added = append(added, event.Added...)
+		removed = append(removed, event.Removed...)
+
+		if reflect.DeepEqual(event.Endpoints, []string{"localhost:1002"}) {
+			break
+		}
+	}
+
+	if !reflect.DeepEqual(added, []string{"localhost:1002"}) {
+		t.Errorf("incorrect added, got %v", added)
+	}
+
+	if !reflect.DeepEqual(removed, []string{"localhost:1001"}) {
+		t.Errorf("incorrect removed, got %v", removed)
+	}
+}
+
+func TestWatchDebounce(t *testing.T) {
+	set := NewWithDialer(Test, "gotest", NewMemoryStore().Dial)
+	set.WatchQuietPeriod = 50 * time.Millisecond
+	set.WatchMaxDelay = 10 * time.Second
+
+	watch, err := set.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch.Close()
+
+	for i := 1; i <= 5; i++ {
+		ep, err := set.RegisterEndpoint("localhost", i, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ep.Close()
+	}
+
+	event := <-watch.Changes()
+	if len(event.Added) != 5 {
+		t.Errorf("should have all endpoints in one update, got %v", event.Added)
+	}
+
+	if watch.EventCount != 1 {
+		t.Errorf("should have one event, got %d", watch.EventCount)
+	}
+}
+
+func TestWatchDebounceMaxDelay(t *testing.T) {
+	set := NewWithDialer(Test, "gotest", NewMemoryStore().Dial)
+	set.WatchQuietPeriod = 10 * time.Second
+	set.WatchMaxDelay = 50 * time.Millisecond
+
+	watch, err := set.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch.Close()
+
+	ep, err := set.RegisterEndpoint("localhost", 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Close()
+
+	select {
+	case <-watch.Event():
+	case <-time.After(time.Second):
+		t.Fatalf("should update after the max delay")
+	}
+
+	if !reflect.DeepEqual(watch.Endpoints(), []string{"localhost:1"}) {
+		t.Errorf("incorrect endpoints, got %v", watch.Endpoints())
+	}
+}
+
+func TestWatchShrink(t *testing.T) {
+	store := NewMemoryStore()
+	set := NewWithDialer(Test, "gotest", store.Dial)
+	set.WatchMaxRemoved = 0.5
+
+	ep4, err := set.RegisterEndpoint("localhost", 4, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep4.Close()
+
+	// on one session so they all go away at once
+	conn, _ := store.Dial()
+	for i := 1; i <= 3; i++ {
+		data, _ := json.Marshal(newEntity("localhost", i, EndpointOptions{}))
+		if _, err := set.registerEndpoint(conn, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	watch, err := set.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch.Close()
+
+	conn.Close()
+
+	err = <-watch.Errors()
+	if e, ok := err.(*ShrinkError); !ok || e.Previous != 4 || e.Removed != 3 {
+		t.Errorf("should report shrink, got %v", err)
+	}
+
+	if len(watch.Endpoints()) != 4 {
+		t.Errorf("should keep previous endpoints, got %v", watch.Endpoints())
+	}
+
+	// endpoints recover
+	for i := 1; i <= 3; i++ {
+		ep, err := set.RegisterEndpoint("localhost", i, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ep.Close()
+	}
+
+	ep, err := set.RegisterEndpoint("localhost", 5, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Close()
+
+	for len(watch.Endpoints()) != 5 {
+		<-watch.Event()
+	}
+}
+
+func TestWatchShrinkHold(t *testing.T) {
+	set := NewWithDialer(Test, "gotest", NewMemoryStore().Dial)
+	set.WatchMinEndpoints = 2
+	set.WatchShrinkHold = 50 * time.Millisecond
+
+	ep1, _ := set.RegisterEndpoint("localhost", 1, nil)
+	defer ep1.Close()
+
+	ep2, _ := set.RegisterEndpoint("localhost", 2, nil)
+
+	watch, err := set.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch.Close()
+
+	ep2.Close()
+
+	if _, ok := (<-watch.Errors()).(*ShrinkError); !ok {
+		t.Errorf("should report shrink")
+	}
+
+	if len(watch.Endpoints()) != 2 {
+		t.Errorf("should keep previous endpoints, got %v", watch.Endpoints())
+	}
+
+	select {
+	case <-watch.Event():
+	case <-time.After(time.Second):
+		t.Fatalf("should accept shrink after hold")
+	}
+
+	if !reflect.DeepEqual(watch.Endpoints(), []string{"localhost:1"}) {
+		t.Errorf("should accept shrink, got %v", watch.Endpoints())
+	}
+}
+
+func TestWatchShrinkDefaultHold(t *testing.T) {
+	defer func(d time.Duration) { DefaultWatchShrinkHold = d }(DefaultWatchShrinkHold)
+	DefaultWatchShrinkHold = 50 * time.Millisecond
+
+	set := NewWithDialer(Test, "gotest", NewMemoryStore().Dial)
+	set.WatchMinEndpoints = 2
+	set.WatchShrinkHold = 0
+
+	ep1, _ := set.RegisterEndpoint("localhost", 1, nil)
+	defer ep1.Close()
+
+	ep2, _ := set.RegisterEndpoint("localhost", 2, nil)
+
+	watch, err := set.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch.Close()
+
+	ep2.Close()
+
+	if _, ok := (<-watch.Errors()).(*ShrinkError); !ok {
+		t.Errorf("should report shrink")
+	}
+
+	select {
+	case <-watch.Event():
+	case <-time.After(time.Second):
+		t.Fatalf("should accept shrink after the default hold")
+	}
+
+	if !reflect.DeepEqual(watch.Endpoints(), []string{"localhost:1"}) {
+		t.Errorf("should accept shrink, got %v", watch.Endpoints())
+	}
+}
@@ -0,0 +1,68 @@
+package serversets
+
+// A Watcher is the part of a watch used by the load balancing packages, httpset, mcset
+// and thriftset, so a fixed set or a stub can be used instead. Watch and MultiWatch are Watchers.
+type Watcher interface {
+	Endpoints() []string
+	Event() <-chan struct{}
+	IsClosed() bool
+}
+
+// A subscriber is a Watcher that can give each consumer its own events, like Watch.
+type subscriber interface {
+	Subscribe(buffer int) *Subscription
+	Unsubscribe(*Subscription)
+}
+
+// WatcherEvents returns a channel that gets an object whenever the endpoints of the watcher change.
+// If the watcher supports it, like Watch, a subscription is used so other readers of Event()
+// don't take some of the events. The returned function stops the subscription, which closes
+// the channel, it should be called once the events are no longer read.
+func WatcherEvents(watcher Watcher) (<-chan struct{}, func()) {
+	s, ok := watcher.(subscriber)
+	if !ok {
+		return watcher.Event(), func() {}
+	}
+
+	sub := s.Subscribe(1)
+	event := make(chan struct{}, 1)
+	go func() {
+		defer close(event)
+		for range sub.Events() {
+			select {
+			case event <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return event, func() { s.Unsubscribe(sub) }
+}
+
+// A weighter is a Watcher that knows the weight of each endpoint, like Watch.
+type weighter interface {
+	Weights() map[string]int
+}
+
+// WatcherWeights returns the weight of each endpoint of the watcher, nil if it doesn't have them.
+func WatcherWeights(watcher Watcher) map[string]int {
+	if w, ok := watcher.(weighter); ok {
+		return w.Weights()
+	}
+
+	return nil
+}
+
+// A memberer is a Watcher that has the member records, like Watch.
+type memberer interface {
+	Members() []Member
+}
+
+// WatcherMembers returns the members of the watcher, nil if it doesn't have them.
+func WatcherMembers(watcher Watcher) []Member {
+	if m, ok := watcher.(memberer); ok {
+		return m.Members()
+	}
+
+	return nil
+}
@@ -0,0 +1,186 @@
+package serversets
+
+import (
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// zkBackend implements the Backend interface on top of a Zookeeper connection.
+type zkBackend struct {
+	conn   *zk.Conn
+	events chan SessionEvent
+}
+
+func dialZookeeper(servers []string, timeout time.Duration, auth []Auth) (Backend, error) {
+	conn, zkEvents, err := zk.Connect(servers, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &zkBackend{
+		conn:   conn,
+		events: make(chan SessionEvent, 6),
+	}
+
+	if err := b.addAuth(auth); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// zkEvents is closed when the connection is closed.
+	go func() {
+		connected := false
+		for event := range zkEvents {
+			if event.Type != zk.EventSession {
+				continue
+			}
+
+			var state SessionState
+			switch event.State {
+			case zk.StateHasSession:
+				state = SessionConnected
+
+				// auth is per connection, the zk library doesn't add it again after reconnecting.
+				if connected {
+					b.addAuth(auth)
+				}
+				connected = true
+			case zk.StateDisconnected:
+				state = SessionDisconnected
+			case zk.StateExpired:
+				state = SessionExpired
+			default:
+				continue
+			}
+
+			// same as the zk library, drop events if no one is listening
+			select {
+			case b.events <- SessionEvent{State: state}:
+			default:
+			}
+		}
+	}()
+
+	return b, nil
+}
+
+func (b *zkBackend) addAuth(auth []Auth) error {
+	for _, a := range auth {
+		if err := b.conn.AddAuth(a.Scheme, a.Credentials); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *zkBackend) CreatePath(path string, acl []ACL) error {
+	// TODO: can't we just create all? ie. mkdir -p
+	for _, key := range splitPaths(path) {
+		_, err := b.conn.Create(key, nil, 0, toZKACL(acl))
+		if err != nil && err != zk.ErrNodeExists {
+			return zkError(err)
+		}
+	}
+
+	return nil
+}
+
+func (b *zkBackend) CreateMember(prefix string, data []byte, acl []ACL) (string, error) {
+	key, err := b.conn.Create(
+		prefix,
+		data,
+		zk.FlagEphemeral|zk.FlagSequence,
+		toZKACL(acl))
+
+	return key, zkError(err)
+}
+
+func (b *zkBackend) SetMember(path string, data []byte) error {
+	_, err := b.conn.Set(path, data, -1)
+	return zkError(err)
+}
+
+func (b *zkBackend) DeleteMember(path string) error {
+	return zkError(b.conn.Delete(path, -1))
+}
+
+func (b *zkBackend) Get(path string) ([]byte, *Stat, error) {
+	data, stat, err := b.conn.Get(path)
+	if err != nil {
+		return nil, nil, zkError(err)
+	}
+
+	return data, &Stat{
+		Created:  zkTime(stat.Ctime),
+		Modified: zkTime(stat.Mtime),
+	}, nil
+}
+
+func (b *zkBackend) GetW(path string) ([]byte, *Stat, <-chan struct{}, error) {
+	data, stat, zkEvents, err := b.conn.GetW(path)
+	if err != nil {
+		return nil, nil, nil, zkError(err)
+	}
+
+	return data, &Stat{
+		Created:  zkTime(stat.Ctime),
+		Modified: zkTime(stat.Mtime),
+	}, zkWatch(zkEvents), nil
+}
+
+func (b *zkBackend) Children(path string) ([]string, error) {
+	children, _, err := b.conn.Children(path)
+	if err != nil {
+		return nil, zkError(err)
+	}
+
+	return children, nil
+}
+
+func (b *zkBackend) ChildrenW(path string) ([]string, <-chan struct{}, error) {
+	children, _, zkEvents, err := b.conn.ChildrenW(path)
+	if err != nil {
+		return nil, nil, zkError(err)
+	}
+
+	return children, zkWatch(zkEvents), nil
+}
+
+// zkWatch closes the returned channel when the zk watch fires.
+// zk watches get exactly one event, on change or when the connection closes.
+func zkWatch(zkEvents <-chan zk.Event) <-chan struct{} {
+	changed := make(chan struct{})
+	go func() {
+		<-zkEvents
+		close(changed)
+	}()
+
+	return changed
+}
+
+func (b *zkBackend) SessionEvents() <-chan SessionEvent {
+	return b.events
+}
+
+func (b *zkBackend) Close() {
+	b.conn.Close()
+}
+
+// zkTime converts zk milliseconds since epoch to a time.
+func zkTime(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// zkError maps zk errors to the ones exported by this package.
+func zkError(err error) error {
+	switch err {
+	case zk.ErrNoNode:
+		return ErrNoNode
+	case zk.ErrSessionExpired, zk.ErrClosing:
+		return ErrSessionClosed
+	}
+
+	return err
+}
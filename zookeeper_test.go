@@ -0,0 +1,25 @@
+package serversets
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+func TestZKError(t *testing.T) {
+	if err := zkError(zk.ErrNoNode); err != ErrNoNode {
+		t.Errorf("should map no node, got %v", err)
+	}
+
+	for _, e := range []error{zk.ErrSessionExpired, zk.ErrClosing} {
+		if err := zkError(e); err != ErrSessionClosed {
+			t.Errorf("should map %v to a closed session, got %v", e, err)
+		}
+	}
+
+	other := errors.New("other")
+	if err := zkError(other); err != other {
+		t.Errorf("should keep other errors, got %v", err)
+	}
+}